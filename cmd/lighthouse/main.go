@@ -4,10 +4,16 @@ import (
 	_ "embed"
 	"github.com/go-playground/validator/v10"
 	"github.com/yunqi/lighthouse/config"
+	_ "github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	_ "github.com/yunqi/lighthouse/internal/persistence/queue/redis"
+	_ "github.com/yunqi/lighthouse/internal/persistence/retained/memory"
+	_ "github.com/yunqi/lighthouse/internal/persistence/retained/redis"
 	_ "github.com/yunqi/lighthouse/internal/persistence/session/memory"
 	_ "github.com/yunqi/lighthouse/internal/persistence/session/redis"
 	_ "github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
 	_ "github.com/yunqi/lighthouse/internal/persistence/subscription/redis"
+	_ "github.com/yunqi/lighthouse/internal/persistence/unack/mem"
+	_ "github.com/yunqi/lighthouse/internal/persistence/unack/redis"
 	"github.com/yunqi/lighthouse/internal/server"
 	"github.com/yunqi/lighthouse/internal/xlog"
 	"github.com/yunqi/lighthouse/internal/xtrace"
@@ -20,7 +26,7 @@ import (
 var configBytes []byte
 
 func main() {
-	c := new(config.Config)
+	c := &config.Config{Mqtt: config.DefaultMqtt}
 	err := yaml.Unmarshal(configBytes, &c)
 	if err != nil {
 		panic(err)
@@ -42,6 +48,6 @@ func main() {
 		_ = http.ListenAndServe("localhost:6060", nil)
 	}()
 
-	newServer := server.NewServer(server.WithTcpListen(":1883"), server.WithPersistence(&c.Persistence))
+	newServer := server.NewServer(server.WithTcpListen(":1883"), server.WithMqtt(&c.Mqtt), server.WithPersistence(&c.Persistence))
 	newServer.ServeTCP()
 }
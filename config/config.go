@@ -20,6 +20,52 @@ import (
 	"time"
 )
 
+// The delivery modes of the overlapping subscriptions.
+const (
+	DeliveryModeOverlap  = "overlap"
+	DeliveryModeOnlyOnce = "onlyonce"
+)
+
+// The strategies of the shared subscription.
+const (
+	SharedSubStrategyRandom        = "random"
+	SharedSubStrategyRoundRobin    = "roundrobin"
+	SharedSubStrategyLeastInflight = "leastinflight"
+	SharedSubStrategyClientIdHash  = "clientidhash"
+	SharedSubStrategyTopicHash     = "topichash"
+)
+
+// The modes of the payload format validation.
+const (
+	PayloadFormatCheckEnforce = "enforce"
+	PayloadFormatCheckLog     = "log"
+	PayloadFormatCheckOff     = "off"
+)
+
+// DefaultMqtt is the default configuration of the mqtt server.
+var DefaultMqtt = Mqtt{
+	SessionExpiry:              2 * time.Hour,
+	SessionExpiryCheckInterval: 20 * time.Second,
+	MessageExpiry:              2 * time.Hour,
+	InflightExpiry:             30 * time.Second,
+	MaxPacketSize:              268435456,
+	ReceiveMax:                 100,
+	MaxKeepAlive:               300,
+	TopicAliasMax:              10,
+	SubscriptionIDAvailable:    true,
+	SharedSubAvailable:         true,
+	SharedSubStrategy:          SharedSubStrategyRandom,
+	WildcardAvailable:          true,
+	RetainAvailable:            true,
+	MaxQueueMessages:           10000,
+	MaxInflight:                100,
+	MaximumQoS:                 2,
+	QueueQos0Msg:               true,
+	DeliveryMode:               DeliveryModeOnlyOnce,
+	AllowZeroLenClientId:       true,
+	PayloadFormatCheck:         PayloadFormatCheckEnforce,
+}
+
 type Config struct {
 	Mqtt        Mqtt        `yaml:"mqtt"`
 	Log         Log         `yaml:"log"`
@@ -57,10 +103,22 @@ type Mqtt struct {
 	SubscriptionIDAvailable bool `yaml:"subscriptionIdentifierAvailable"`
 	// SharedSubAvailable indicates whether the server supports Shared Subscriptions.
 	SharedSubAvailable bool `yaml:"sharedSubscriptionAvailable"`
+	// SharedSubStrategy is the strategy used to choose a subscriber of a shared subscription group.
+	// The possible value can be "random", "roundrobin", "leastinflight", "clientidhash" or "topichash".
+	// When set to "random", the server will choose a subscriber randomly.
+	// When set to "roundrobin", the server will choose the subscribers of the group in turn.
+	// When set to "leastinflight", the server will choose the subscriber which has the least inflight messages.
+	// When set to "clientidhash", the server will choose the subscriber by the hash of the publisher client id.
+	// When set to "topichash", the server will choose the subscriber by the hash of the topic name,
+	// so that messages of the same topic are delivered to the same subscriber in order.
+	SharedSubStrategy string `yaml:"sharedSubscriptionStrategy"`
 	// WildcardSubAvailable indicates whether the server supports Wildcard Subscriptions.
 	WildcardAvailable bool `yaml:"wildcardSubscriptionAvailable"`
 	// RetainAvailable indicates whether the server supports retained messages.
 	RetainAvailable bool `yaml:"retainAvailable"`
+	// MaxSubscriptions is the maximum number of subscriptions of a client.
+	// If set to 0, there is no limit.
+	MaxSubscriptions int `yaml:"maxSubscriptions"`
 	// MaxQueuedMsg is the maximum queue length of the outgoing messages.
 	// If the queue is full, some message will be dropped.
 	// The message dropping strategy is described in the document of the persistence/queue.Store interface.
@@ -80,4 +138,11 @@ type Mqtt struct {
 	DeliveryMode string `yaml:"deliveryMode"`
 	// AllowZeroLenClientId indicates whether to allow a client to connect with empty client id.
 	AllowZeroLenClientId bool `yaml:"allowZeroLenClientId"`
+	// PayloadFormatCheck is the mode of validating the payload which is indicated as UTF-8 by the Payload Format Indicator.
+	// The possible value can be "enforce", "log" or "off".
+	// When set to "enforce", the server will reject the message whose payload is not valid UTF-8.
+	// When set to "log", the server will only log the invalid payload and deliver the message.
+	// When set to "off", the server will not validate the payload, which saves the CPU cost on large payloads.
+	// No-op if the client version is MQTTv3.x
+	PayloadFormatCheck string `yaml:"payloadFormatCheck"`
 }
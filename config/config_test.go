@@ -0,0 +1,38 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+	"time"
+)
+
+func TestDefaultMqtt(t *testing.T) {
+	c := &Config{Mqtt: DefaultMqtt}
+	err := yaml.Unmarshal([]byte("mqtt:\n  sessionExpiry: 1h\n  maxQueueMessages: 10\n"), c)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, c.Mqtt.SessionExpiry)
+	assert.Equal(t, 10, c.Mqtt.MaxQueueMessages)
+	// the options which are not configured keep the default values.
+	assert.Equal(t, DefaultMqtt.SessionExpiryCheckInterval, c.Mqtt.SessionExpiryCheckInterval)
+	assert.Equal(t, DefaultMqtt.InflightExpiry, c.Mqtt.InflightExpiry)
+	assert.Equal(t, DefaultMqtt.MaxInflight, c.Mqtt.MaxInflight)
+	assert.Equal(t, DefaultMqtt.DeliveryMode, c.Mqtt.DeliveryMode)
+	assert.Equal(t, uint8(2), DefaultMqtt.MaximumQoS)
+}
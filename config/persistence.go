@@ -7,6 +7,8 @@ type (
 		Session      StoreType `yaml:"session"`
 		Subscription StoreType `yaml:"subscription"`
 		Queue        StoreType `yaml:"queue"`
+		Retained     StoreType `yaml:"retained"`
+		Unack        StoreType `yaml:"unack"`
 	}
 
 	StoreType struct {
@@ -0,0 +1,48 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package auth provides the enhanced authentication methods of MQTT v5.
+// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901256
+package auth
+
+import "errors"
+
+var (
+	// ErrNotAuthorized means the credentials of the client are invalid.
+	ErrNotAuthorized = errors.New("not authorized")
+	// ErrBadAuthData means the authentication data can not be parsed.
+	ErrBadAuthData = errors.New("bad authentication data")
+)
+
+type (
+	// Method is an enhanced authentication method which is identified by the Authentication Method property.
+	Method interface {
+		// Name returns the name of the authentication method, such as "SCRAM-SHA-256".
+		Name() string
+		// Start begins a new authentication exchange.
+		Start() Exchange
+	}
+	// Exchange is the state of one authentication exchange between the server and a client.
+	// An exchange is not safe for concurrent use.
+	Exchange interface {
+		// Next handles the Authentication Data received from the client.
+		// It returns the Authentication Data sent back to the client and whether the authentication has completed.
+		// A non-nil error means the authentication has failed.
+		Next(data []byte) (resp []byte, done bool, err error)
+		// Username returns the authenticated user name, it is only available after the authentication has completed.
+		Username() string
+	}
+)
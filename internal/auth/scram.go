@@ -0,0 +1,241 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// ScramSha256 is the name of the SCRAM-SHA-256 authentication method.
+const ScramSha256 = "SCRAM-SHA-256"
+
+const (
+	// scramNonceLen is the length of the random bytes of the server nonce.
+	scramNonceLen = 18
+	// scramFakeIterations is the iteration count sent to the client whose user does not exist.
+	scramFakeIterations = 4096
+)
+
+type (
+	// ScramCredential is the credential of a user stored by the server.
+	// See: https://datatracker.ietf.org/doc/html/rfc5802#section-3
+	ScramCredential struct {
+		Salt       []byte
+		Iterations int
+		StoredKey  []byte
+		ServerKey  []byte
+	}
+	// ScramLookup returns the credential of the user, it returns nil if the user does not exist.
+	ScramLookup func(username string) *ScramCredential
+
+	scram struct {
+		lookup ScramLookup
+		// secret derives the fake salts of the users which do not exist.
+		secret []byte
+	}
+	// scramExchange is the server side of a SCRAM-SHA-256 exchange.
+	scramExchange struct {
+		lookup ScramLookup
+		secret []byte
+		step   int
+		// the values of the client-first-message and the server-first-message.
+		username        string
+		gs2Header       string
+		clientFirstBare string
+		serverFirst     string
+		nonce           string
+		credential      *ScramCredential
+		// unknownUser is true if the user does not exist, the exchange fails at the proof check.
+		unknownUser bool
+	}
+)
+
+// NewScramCredential derives the credential of the password as defined in RFC 5802.
+func NewScramCredential(password string, salt []byte, iterations int) *ScramCredential {
+	saltedPassword := scramHi([]byte(password), salt, iterations)
+	clientKey := scramHmac(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	return &ScramCredential{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey[:],
+		ServerKey:  scramHmac(saltedPassword, []byte("Server Key")),
+	}
+}
+
+// NewScram returns the SCRAM-SHA-256 authentication method.
+// See: https://datatracker.ietf.org/doc/html/rfc7677
+func NewScram(lookup ScramLookup) Method {
+	secret := make([]byte, sha256.Size)
+	_, _ = rand.Read(secret)
+	return &scram{lookup: lookup, secret: secret}
+}
+
+func (s *scram) Name() string {
+	return ScramSha256
+}
+
+func (s *scram) Start() Exchange {
+	return &scramExchange{lookup: s.lookup, secret: s.secret}
+}
+
+func (e *scramExchange) Next(data []byte) ([]byte, bool, error) {
+	e.step++
+	switch e.step {
+	case 1:
+		resp, err := e.clientFirst(string(data))
+		return resp, false, err
+	case 2:
+		resp, err := e.clientFinal(string(data))
+		return resp, err == nil, err
+	default:
+		return nil, false, ErrBadAuthData
+	}
+}
+
+func (e *scramExchange) Username() string {
+	return e.username
+}
+
+// clientFirst handles the client-first-message: gs2-header client-first-message-bare.
+func (e *scramExchange) clientFirst(msg string) ([]byte, error) {
+	// channel binding is not supported, the gs2-cbind-flag must be "n" or "y".
+	if !strings.HasPrefix(msg, "n,") && !strings.HasPrefix(msg, "y,") {
+		return nil, ErrBadAuthData
+	}
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) != 3 || parts[1] != "" {
+		// authzid is not supported.
+		return nil, ErrBadAuthData
+	}
+	e.gs2Header = parts[0] + "," + parts[1] + ","
+	e.clientFirstBare = parts[2]
+	attrs, err := scramAttributes(e.clientFirstBare)
+	if err != nil {
+		return nil, err
+	}
+	username, clientNonce := attrs["n"], attrs["r"]
+	if username == "" || clientNonce == "" {
+		return nil, ErrBadAuthData
+	}
+	e.username = scramUnescape(username)
+	e.credential = e.lookup(e.username)
+	if e.credential == nil {
+		// The server-first-message of an unknown user looks like the one of an existing user,
+		// so that the client can not find out which users exist. See RFC 5802 section 9.
+		e.unknownUser = true
+		e.credential = e.fakeCredential()
+	}
+	nonce := make([]byte, scramNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	e.nonce = clientNonce + base64.StdEncoding.EncodeToString(nonce)
+	e.serverFirst = "r=" + e.nonce +
+		",s=" + base64.StdEncoding.EncodeToString(e.credential.Salt) +
+		",i=" + strconv.Itoa(e.credential.Iterations)
+	return []byte(e.serverFirst), nil
+}
+
+// clientFinal handles the client-final-message and verifies the client proof.
+func (e *scramExchange) clientFinal(msg string) ([]byte, error) {
+	i := strings.LastIndex(msg, ",p=")
+	if i < 0 {
+		return nil, ErrBadAuthData
+	}
+	withoutProof := msg[:i]
+	attrs, err := scramAttributes(msg)
+	if err != nil {
+		return nil, err
+	}
+	// the channel binding must be the gs2-header of the client-first-message, since channel binding is not supported.
+	if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(e.gs2Header)) {
+		return nil, ErrBadAuthData
+	}
+	if attrs["r"] != e.nonce {
+		return nil, ErrNotAuthorized
+	}
+	proof, err := base64.StdEncoding.DecodeString(attrs["p"])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, ErrBadAuthData
+	}
+	authMessage := []byte(e.clientFirstBare + "," + e.serverFirst + "," + withoutProof)
+	clientSignature := scramHmac(e.credential.StoredKey, authMessage)
+	clientKey := make([]byte, sha256.Size)
+	for i := range clientKey {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if !hmac.Equal(storedKey[:], e.credential.StoredKey) || e.unknownUser {
+		return nil, ErrNotAuthorized
+	}
+	serverSignature := scramHmac(e.credential.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// fakeCredential returns the credential of the user which does not exist.
+// The salt is derived from the user name, so the same salt is sent every time the user is tried.
+func (e *scramExchange) fakeCredential() *ScramCredential {
+	salt := scramHmac(e.secret, []byte("salt:"+e.username))
+	return &ScramCredential{
+		Salt:       salt[:16],
+		Iterations: scramFakeIterations,
+		StoredKey:  scramHmac(e.secret, []byte("stored key:"+e.username)),
+		ServerKey:  scramHmac(e.secret, []byte("server key:"+e.username)),
+	}
+}
+
+// scramAttributes parses the comma separated attribute-value pairs.
+func scramAttributes(msg string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, kv := range strings.Split(msg, ",") {
+		if len(kv) < 2 || kv[1] != '=' {
+			return nil, ErrBadAuthData
+		}
+		attrs[kv[:1]] = kv[2:]
+	}
+	return attrs, nil
+}
+
+// scramUnescape replaces "=2C" and "=3D" in the user name with "," and "=".
+func scramUnescape(s string) string {
+	return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(s)
+}
+
+func scramHmac(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// scramHi is the Hi function defined in RFC 5802, which is PBKDF2 with HMAC-SHA-256 and one block.
+func scramHi(password, salt []byte, iterations int) []byte {
+	u := scramHmac(password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = scramHmac(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scramClientFinal computes the client-final-message from the server-first-message.
+// channelBinding is the base64 encoded gs2-header sent in the client-final-message.
+func scramClientFinal(t *testing.T, password, channelBinding, clientFirstBare, serverFirst string) (string, []byte) {
+	attrs, err := scramAttributes(serverFirst)
+	assert.NoError(t, err)
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	assert.NoError(t, err)
+	iterations, err := strconv.Atoi(attrs["i"])
+	assert.NoError(t, err)
+
+	saltedPassword := scramHi([]byte(password), salt, iterations)
+	clientKey := scramHmac(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=" + channelBinding + ",r=" + attrs["r"]
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + withoutProof)
+	clientSignature := scramHmac(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range proof {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	serverSignature := scramHmac(scramHmac(saltedPassword, []byte("Server Key")), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), serverSignature
+}
+
+func TestScram(t *testing.T) {
+	credential := NewScramCredential("pencil", []byte("salt"), 4096)
+	method := NewScram(func(username string) *ScramCredential {
+		if username == "user" {
+			return credential
+		}
+		return nil
+	})
+	assert.Equal(t, ScramSha256, method.Name())
+
+	t.Run("success", func(t *testing.T) {
+		e := method.Start()
+		serverFirst, done, err := e.Next([]byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"))
+		assert.NoError(t, err)
+		assert.False(t, done)
+		assert.True(t, strings.HasPrefix(string(serverFirst), "r=fyko+d2lbbFgONRv9qkxdawL"))
+
+		clientFinal, serverSignature := scramClientFinal(t, "pencil", "biws", "n=user,r=fyko+d2lbbFgONRv9qkxdawL", string(serverFirst))
+		serverFinal, done, err := e.Next([]byte(clientFinal))
+		assert.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, "v="+base64.StdEncoding.EncodeToString(serverSignature), string(serverFinal))
+		assert.Equal(t, "user", e.Username())
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		e := method.Start()
+		serverFirst, _, err := e.Next([]byte("n,,n=user,r=abc"))
+		assert.NoError(t, err)
+		clientFinal, _ := scramClientFinal(t, "wrong", "biws", "n=user,r=abc", string(serverFirst))
+		_, done, err := e.Next([]byte(clientFinal))
+		assert.ErrorIs(t, err, ErrNotAuthorized)
+		assert.False(t, done)
+	})
+
+	t.Run("channel binding", func(t *testing.T) {
+		for _, tt := range []struct {
+			gs2Header, channelBinding string
+			err                       error
+		}{
+			{gs2Header: "y,,", channelBinding: "eSws"},
+			{gs2Header: "n,,", channelBinding: "eSws", err: ErrBadAuthData},
+			{gs2Header: "y,,", channelBinding: "biws", err: ErrBadAuthData},
+		} {
+			e := method.Start()
+			serverFirst, _, err := e.Next([]byte(tt.gs2Header + "n=user,r=abc"))
+			assert.NoError(t, err)
+			clientFinal, _ := scramClientFinal(t, "pencil", tt.channelBinding, "n=user,r=abc", string(serverFirst))
+			_, done, err := e.Next([]byte(clientFinal))
+			assert.Equal(t, tt.err, err, tt.gs2Header+tt.channelBinding)
+			assert.Equal(t, tt.err == nil, done)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		serverFirst := func(username string) map[string]string {
+			resp, done, err := method.Start().Next([]byte("n,,n=" + username + ",r=abc"))
+			assert.NoError(t, err)
+			assert.False(t, done)
+			attrs, err := scramAttributes(string(resp))
+			assert.NoError(t, err)
+			return attrs
+		}
+		// the unknown user gets the same salt every time, which differs from the one of the others.
+		attrs := serverFirst("nobody")
+		assert.Equal(t, attrs["s"], serverFirst("nobody")["s"])
+		assert.NotEqual(t, attrs["s"], serverFirst("somebody")["s"])
+		assert.Equal(t, "4096", attrs["i"])
+
+		e := method.Start()
+		resp, _, err := e.Next([]byte("n,,n=nobody,r=abc"))
+		assert.NoError(t, err)
+		clientFinal, _ := scramClientFinal(t, "pencil", "biws", "n=nobody,r=abc", string(resp))
+		_, done, err := e.Next([]byte(clientFinal))
+		assert.ErrorIs(t, err, ErrNotAuthorized)
+		assert.False(t, done)
+	})
+
+	t.Run("bad data", func(t *testing.T) {
+		for _, data := range []string{"", "p=tls-unique,,n=user,r=abc", "n,a=admin,n=user,r=abc", "n,,n=user", "n,,garbage"} {
+			_, _, err := method.Start().Next([]byte(data))
+			assert.ErrorIs(t, err, ErrBadAuthData, data)
+		}
+	})
+}
@@ -27,6 +27,9 @@ const (
 	V3NotAuthorized               Code = 0x05
 )
 
+// V3SubackFailure is the failure return code in v311 suback packet.
+const V3SubackFailure Code = 0x80
+
 // There are the possible reason Code in v5
 const (
 	Success                     Code = 0x00
@@ -0,0 +1,100 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"io"
+)
+
+type (
+	// Auth is the AUTH packet which is used in the enhanced authentication, only available in v5.
+	Auth struct {
+		Version     Version
+		FixedHeader *FixedHeader
+		// Code is the Authenticate Reason Code.
+		Code code.Code
+		// Properties is the auth properties.
+		Properties *Properties
+	}
+)
+
+// NewAuth returns an Auth instance by the given FixHeader and io.Reader.
+func NewAuth(fixedHeader *FixedHeader, version Version, r io.Reader) (*Auth, error) {
+	// It is a Protocol Error to send an AUTH packet in v3.
+	if !IsVersion5(version) {
+		return nil, xerror.ErrProtocol
+	}
+	// [MQTT-3.15.1-1]
+	if fixedHeader.Flags != FixedHeaderFlagReserved {
+		return nil, xerror.ErrMalformed
+	}
+	p := &Auth{FixedHeader: fixedHeader, Version: version}
+	err := p.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (a *Auth) Encode(w io.Writer) (err error) {
+	a.FixedHeader = &FixedHeader{PacketType: AUTH, Flags: FixedHeaderFlagReserved}
+	// The Reason Code and Property Length can be omitted if the Reason Code is 0x00 (Success) and there are no Properties.
+	if a.Code == code.Success && a.Properties == nil {
+		return a.FixedHeader.Encode(w)
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte(a.Code)
+	if err = a.Properties.Pack(buf); err != nil {
+		return err
+	}
+	return encode(a.FixedHeader, buf, w)
+}
+
+func (a *Auth) Decode(r io.Reader) (err error) {
+	if a.FixedHeader.RemainLength == 0 {
+		a.Code = code.Success
+		return
+	}
+	restBuffer := make([]byte, a.FixedHeader.RemainLength)
+	_, err = io.ReadFull(r, restBuffer)
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	buf := bytes.NewBuffer(restBuffer)
+	a.Code, err = buf.ReadByte()
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	switch a.Code {
+	case code.Success, code.ContinueAuthentication, code.ReAuthenticate:
+	default:
+		return xerror.ErrProtocol
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	a.Properties, err = unpackProperties(buf, AUTH)
+	return err
+}
+
+func (a *Auth) String() string {
+	return fmt.Sprintf("Auth - Version: %s, Code: %v, Properties: %s", a.Version, a.Code, a.Properties)
+}
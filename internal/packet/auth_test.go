@@ -0,0 +1,79 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	t.Run("continue", func(t *testing.T) {
+		auth := &Auth{
+			Version:    Version5,
+			Code:       code.ContinueAuthentication,
+			Properties: &Properties{AuthMethod: []byte("m"), AuthData: []byte{1}},
+		}
+		buff := &bytes.Buffer{}
+		assert.NoError(t, auth.Encode(buff))
+		assert.Equal(t, []byte{0xf0, 0x0a, code.ContinueAuthentication, 0x08, PropAuthMethod, 0x00, 0x01, 'm', PropAuthData, 0x00, 0x01, 0x01}, buff.Bytes())
+
+		reader := NewReader(buff)
+		reader.version = Version5
+		p, err := reader.Read()
+		assert.NoError(t, err)
+		if got, ok := p.(*Auth); assert.True(t, ok) {
+			assert.Equal(t, auth.Code, got.Code)
+			assert.Equal(t, auth.Properties, got.Properties)
+		}
+	})
+	t.Run("success without properties", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		assert.NoError(t, (&Auth{Version: Version5}).Encode(buff))
+		assert.Equal(t, []byte{0xf0, 0x00}, buff.Bytes())
+
+		reader := NewReader(buff)
+		reader.version = Version5
+		p, err := reader.Read()
+		assert.NoError(t, err)
+		if got, ok := p.(*Auth); assert.True(t, ok) {
+			assert.Equal(t, code.Success, got.Code)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewReader(bytes.NewBuffer([]byte{0xf0, 0x00})).Read()
+		assert.ErrorIs(t, err, xerror.ErrProtocol)
+
+		reader := NewReader(bytes.NewBuffer([]byte{0xf0, 0x02, code.NotAuthorized, 0x00}))
+		reader.version = Version5
+		_, err = reader.Read()
+		assert.ErrorIs(t, err, xerror.ErrProtocol)
+
+		reader = NewReader(bytes.NewBuffer([]byte{0xf1, 0x00}))
+		reader.version = Version5
+		_, err = reader.Read()
+		assert.ErrorIs(t, err, xerror.ErrMalformed)
+
+		reader = NewReader(bytes.NewBuffer([]byte{0xf0, 0x04, code.ContinueAuthentication, 0x02, PropReceiveMaximum, 0x01}))
+		reader.version = Version5
+		_, err = reader.Read()
+		assert.Error(t, err)
+	})
+}
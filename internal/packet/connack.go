@@ -29,6 +29,58 @@ type Connack struct {
 	FixedHeader    *FixedHeader
 	SessionPresent bool
 	Code           code.Code
+	// Properties is the connack properties, only available in v5.
+	Properties *Properties
+}
+
+// v3ConnackCodes converts the reason codes of v5 to the return codes of v3.
+var v3ConnackCodes = map[code.Code]code.Code{
+	code.Success:                     code.V3Accepted,
+	code.UnspecifiedError:            code.V3ServerUnavaliable,
+	code.ImplementationSpecificError: code.V3ServerUnavaliable,
+	code.UnsupportedProtocolVersion:  code.V3UnacceptableProtocolVersion,
+	code.ClientIdentifierNotValid:    code.V3IdentifierRejected,
+	code.BadUserNameOrPassword:       code.V3BadUsernameorPassword,
+	code.NotAuthorized:               code.V3NotAuthorized,
+	code.ServerUnavailable:           code.V3ServerUnavaliable,
+	code.ServerBusy:                  code.V3ServerUnavaliable,
+	code.Banned:                      code.V3NotAuthorized,
+	code.BadAuthMethod:               code.V3NotAuthorized,
+	code.QuotaExceeded:               code.V3ServerUnavaliable,
+	code.UseAnotherServer:            code.V3ServerUnavaliable,
+	code.ServerMoved:                 code.V3ServerUnavaliable,
+	code.ConnectionRateExceeded:      code.V3ServerUnavaliable,
+}
+
+// v5ConnackCodes converts the return codes of v3 to the reason codes of v5.
+var v5ConnackCodes = map[code.Code]code.Code{
+	code.V3UnacceptableProtocolVersion: code.UnsupportedProtocolVersion,
+	code.V3IdentifierRejected:          code.ClientIdentifierNotValid,
+	code.V3ServerUnavaliable:           code.ServerUnavailable,
+	code.V3BadUsernameorPassword:       code.BadUserNameOrPassword,
+	code.V3NotAuthorized:               code.NotAuthorized,
+}
+
+// ConnackCode converts the code to the code of the CONNACK packet in the given version.
+// It returns false if there is no such code in the version,
+// in which case the server should close the connection without sending the CONNACK packet. [MQTT-3.1.4-1]
+func ConnackCode(version Version, cd code.Code) (code.Code, bool) {
+	if IsVersion5(version) {
+		if cd == code.Success || cd >= code.UnspecifiedError {
+			return cd, true
+		}
+		if v5, ok := v5ConnackCodes[cd]; ok {
+			return v5, true
+		}
+		return code.UnspecifiedError, false
+	}
+	if cd <= code.V3NotAuthorized {
+		return cd, true
+	}
+	if v3, ok := v3ConnackCodes[cd]; ok {
+		return v3, true
+	}
+	return code.V3ServerUnavaliable, false
 }
 
 // NewConnack returns a Connack instance by the given FixHeader and io.Reader
@@ -55,6 +107,11 @@ func (c *Connack) Encode(w io.Writer) (err error) {
 	}
 	// Connect Return code
 	buf.WriteByte(c.Code)
+	if IsVersion5(c.Version) {
+		if err = c.Properties.Pack(buf); err != nil {
+			return err
+		}
+	}
 
 	return encode(c.FixedHeader, buf, w)
 }
@@ -69,6 +126,9 @@ func (c *Connack) Decode(r io.Reader) (err error) {
 	buf := bytes.NewBuffer(restBuffer)
 	// 当前会话
 	sessionPresentByte, err := buf.ReadByte()
+	if err != nil {
+		return xerror.ErrMalformed
+	}
 	if (127 & (sessionPresentByte >> 1)) > 0 {
 		return xerror.ErrMalformed
 	}
@@ -79,10 +139,17 @@ func (c *Connack) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	c.Code = codeByte
+	if IsVersion5(c.Version) {
+		c.Properties, err = unpackProperties(buf, CONNACK)
+	}
 	return
 }
 
 func (c *Connack) String() string {
+	if IsVersion5(c.Version) {
+		return fmt.Sprintf("Connack - Version: %s, SessionPresent: %v, Code: %v, Properties: %s",
+			c.Version, c.SessionPresent, c.Code, c.Properties)
+	}
 	return fmt.Sprintf("Connack - Version: %s, SessionPresent: %v, Code: %v",
 		c.Version, c.SessionPresent, c.Code)
 }
@@ -132,6 +132,54 @@ func TestConnack_Encode(t *testing.T) {
 	})
 }
 
+func TestConnackCode(t *testing.T) {
+	for name, tt := range map[string]struct {
+		cd     code.Code
+		v3, v5 code.Code
+		v3Ok   bool
+	}{
+		"success":                          {cd: code.Success, v3: code.V3Accepted, v5: code.Success, v3Ok: true},
+		"unsupported protocol version":     {cd: code.UnsupportedProtocolVersion, v3: code.V3UnacceptableProtocolVersion, v5: code.UnsupportedProtocolVersion, v3Ok: true},
+		"client identifier not valid":      {cd: code.ClientIdentifierNotValid, v3: code.V3IdentifierRejected, v5: code.ClientIdentifierNotValid, v3Ok: true},
+		"bad username or password":         {cd: code.BadUserNameOrPassword, v3: code.V3BadUsernameorPassword, v5: code.BadUserNameOrPassword, v3Ok: true},
+		"not authorized":                   {cd: code.NotAuthorized, v3: code.V3NotAuthorized, v5: code.NotAuthorized, v3Ok: true},
+		"banned":                           {cd: code.Banned, v3: code.V3NotAuthorized, v5: code.Banned, v3Ok: true},
+		"bad auth method":                  {cd: code.BadAuthMethod, v3: code.V3NotAuthorized, v5: code.BadAuthMethod, v3Ok: true},
+		"unspecified error":                {cd: code.UnspecifiedError, v3: code.V3ServerUnavaliable, v5: code.UnspecifiedError, v3Ok: true},
+		"implementation specific error":    {cd: code.ImplementationSpecificError, v3: code.V3ServerUnavaliable, v5: code.ImplementationSpecificError, v3Ok: true},
+		"server unavailable":               {cd: code.ServerUnavailable, v3: code.V3ServerUnavaliable, v5: code.ServerUnavailable, v3Ok: true},
+		"server busy":                      {cd: code.ServerBusy, v3: code.V3ServerUnavaliable, v5: code.ServerBusy, v3Ok: true},
+		"quota exceeded":                   {cd: code.QuotaExceeded, v3: code.V3ServerUnavaliable, v5: code.QuotaExceeded, v3Ok: true},
+		"use another server":               {cd: code.UseAnotherServer, v3: code.V3ServerUnavaliable, v5: code.UseAnotherServer, v3Ok: true},
+		"server moved":                     {cd: code.ServerMoved, v3: code.V3ServerUnavaliable, v5: code.ServerMoved, v3Ok: true},
+		"connection rate exceeded":         {cd: code.ConnectionRateExceeded, v3: code.V3ServerUnavaliable, v5: code.ConnectionRateExceeded, v3Ok: true},
+		"malformed packet":                 {cd: code.MalformedPacket, v3: code.V3ServerUnavaliable, v5: code.MalformedPacket},
+		"protocol error":                   {cd: code.ProtocolError, v3: code.V3ServerUnavaliable, v5: code.ProtocolError},
+		"packet too large":                 {cd: code.PacketTooLarge, v3: code.V3ServerUnavaliable, v5: code.PacketTooLarge},
+		"v3 unacceptable protocol version": {cd: code.V3UnacceptableProtocolVersion, v3: code.V3UnacceptableProtocolVersion, v5: code.UnsupportedProtocolVersion, v3Ok: true},
+		"v3 identifier rejected":           {cd: code.V3IdentifierRejected, v3: code.V3IdentifierRejected, v5: code.ClientIdentifierNotValid, v3Ok: true},
+		"v3 server unavailable":            {cd: code.V3ServerUnavaliable, v3: code.V3ServerUnavaliable, v5: code.ServerUnavailable, v3Ok: true},
+		"v3 bad username or password":      {cd: code.V3BadUsernameorPassword, v3: code.V3BadUsernameorPassword, v5: code.BadUserNameOrPassword, v3Ok: true},
+		"v3 not authorized":                {cd: code.V3NotAuthorized, v3: code.V3NotAuthorized, v5: code.NotAuthorized, v3Ok: true},
+	} {
+		for _, version := range []Version{Version31, Version311} {
+			cd, ok := ConnackCode(version, tt.cd)
+			assert.Equal(t, tt.v3, cd, name)
+			assert.Equal(t, tt.v3Ok, ok, name)
+		}
+		cd, ok := ConnackCode(Version5, tt.cd)
+		assert.Equal(t, tt.v5, cd, name)
+		assert.True(t, ok, name)
+	}
+
+	cd, ok := ConnackCode(Version5, code.ContinueAuthentication)
+	assert.Equal(t, code.UnspecifiedError, cd)
+	assert.False(t, ok)
+
+	connect := &Connect{Version: Version311}
+	assert.Equal(t, code.V3BadUsernameorPassword, connect.NewConnackPacket(code.BadUserNameOrPassword, false).Code)
+}
+
 func TestConnack_String(t *testing.T) {
 
 	fixedHeader := &FixedHeader{
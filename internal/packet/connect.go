@@ -43,9 +43,13 @@ type (
 		// to elapse between the point at which the Client finishes transmitting one Control Packet
 		// and the point it starts sending the next.
 		KeepAlive uint16
+		// Properties is the connect properties, only available in v5.
+		Properties *Properties
 
-		WillTopic   []byte
-		WillMessage []byte
+		// WillProperties is the will properties, only available in v5.
+		WillProperties *Properties
+		WillTopic      []byte
+		WillMessage    []byte
 
 		//auth
 		ClientId []byte
@@ -90,10 +94,6 @@ func NewConnect(fixedHeader *FixedHeader, version Version, r io.Reader) (*Connec
 	return p, err
 }
 
-var (
-	ProtocolNamePrefix = []byte{0x00, 0x04}
-)
-
 const (
 	_ = 1 << iota
 	CleanSessionTure
@@ -109,8 +109,7 @@ func (c *Connect) Encode(w io.Writer) (err error) {
 	//c.FixedHeader = &FixedHeader{PacketType: CONNECT, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
 	// 协议头
-	buf.Write(ProtocolNamePrefix)
-	buf.Write(c.ProtocolName)
+	writeBinary(buf, c.ProtocolName)
 	buf.WriteByte(c.ProtocolLevel)
 	// connect flags
 	var (
@@ -145,6 +144,11 @@ func (c *Connect) Encode(w io.Writer) (err error) {
 	connectFlags := usernameFlag | passwordFlag | willRetain | willFlag | willQos | CleanSession | reserved
 	buf.Write([]byte{connectFlags})
 	writeUint16(buf, c.KeepAlive)
+	if IsVersion5(c.Version) {
+		if err = c.Properties.Pack(buf); err != nil {
+			return err
+		}
+	}
 
 	// client identifier
 	clientIdBytes, _, err := UTF8EncodedStrings(c.ClientId)
@@ -153,6 +157,11 @@ func (c *Connect) Encode(w io.Writer) (err error) {
 	}
 	buf.Write(clientIdBytes)
 	if c.WillFlag {
+		if IsVersion5(c.Version) {
+			if err = c.WillProperties.Pack(buf); err != nil {
+				return err
+			}
+		}
 		// will topic
 		willTopicBytes, _, err := UTF8EncodedStrings(c.WillTopic)
 		if err != nil {
@@ -204,7 +213,11 @@ func (c *Connect) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	c.Version = Version(c.ProtocolLevel)
-	if _, ok := version2protocolName[c.Version]; !ok {
+	if name, ok := version2protocolName[c.Version]; !ok || name != string(protocolName) {
+		// the client of a newer version may understand the CONNACK packet of v5.
+		if c.Version > Version5 && string(protocolName) == version2protocolName[Version5] {
+			return xerror.ErrUnsupportedProtocolVersion
+		}
 		return xerror.ErrV3UnacceptableProtocolVersion
 	}
 	connectFlags, err := buf.ReadByte()
@@ -231,6 +244,11 @@ func (c *Connect) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return err
 	}
+	if IsVersion5(c.Version) {
+		if c.Properties, err = unpackProperties(buf, CONNECT); err != nil {
+			return err
+		}
+	}
 	return c.decodePayload(buf)
 }
 
@@ -251,11 +269,17 @@ func (c *Connect) decodePayload(buf *bytes.Buffer) error {
 		return xerror.ErrV3IdentifierRejected // v311 //[MQTT-3.1.3-8]
 	}
 	if c.WillFlag {
+		if IsVersion5(c.Version) {
+			if c.WillProperties, err = unpackProperties(buf, propertiesWill); err != nil {
+				return err
+			}
+		}
 		c.WillTopic, err = UTF8DecodedStrings(true, buf)
 		if err != nil {
 			return err
 		}
-		c.WillMessage, err = UTF8DecodedStrings(true, buf)
+		// the will payload is binary data, it is validated by the Payload Format Indicator.
+		c.WillMessage, err = UTF8DecodedStrings(false, buf)
 		if err != nil {
 			return err
 		}
@@ -279,6 +303,7 @@ func (c *Connect) decodePayload(buf *bytes.Buffer) error {
 
 // NewConnackPacket returns the Connack struct which is the ack packet of the Connect packet.
 func (c *Connect) NewConnackPacket(cd code.Code, sessionReuse bool) *Connack {
+	cd, _ = ConnackCode(c.Version, cd)
 	ack := &Connack{Code: cd, Version: c.Version}
 	if !c.CleanSession && sessionReuse && cd == code.Success {
 		ack.SessionPresent = true //[MQTT-3.2.2-2]
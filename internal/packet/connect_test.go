@@ -126,6 +126,47 @@ func TestNewConnect(t *testing.T) {
 		assert.Nil(t, connect)
 	})
 
+	t.Run("protocol version", func(t *testing.T) {
+		for name, tt := range map[string]struct {
+			name  string
+			level byte
+			err   error
+		}{
+			"v31":               {name: "MQIsdp", level: 0x03},
+			"v311":              {name: "MQTT", level: 0x04},
+			"v5":                {name: "MQTT", level: 0x05},
+			"v31 name mismatch": {name: "MQTT", level: 0x03, err: xerror.ErrV3UnacceptableProtocolVersion},
+			"v5 name mismatch":  {name: "MQIsdp", level: 0x05, err: xerror.ErrV3UnacceptableProtocolVersion},
+			"newer version":     {name: "MQTT", level: 0x06, err: xerror.ErrUnsupportedProtocolVersion},
+			"older version":     {name: "MQTT", level: 0x02, err: xerror.ErrV3UnacceptableProtocolVersion},
+			"unknown protocol":  {name: "MQTX", level: 0x06, err: xerror.ErrV3UnacceptableProtocolVersion},
+		} {
+			buf := &bytes.Buffer{}
+			buf.Write([]byte{0x00, byte(len(tt.name))})
+			buf.WriteString(tt.name)
+			buf.Write([]byte{
+				tt.level,
+				0x02,      // Connect Flags
+				0x0, 0x02, // Keep Alive
+			})
+			if tt.level == 0x05 && tt.err == nil {
+				buf.WriteByte(0x00) // Properties
+			}
+			buf.Write([]byte{0x00, 0x01, 't'}) // Client Identifier
+			fixedHeader := &FixedHeader{
+				PacketType:   CONNECT,
+				Flags:        FixedHeaderFlagReserved,
+				RemainLength: buf.Len(),
+			}
+			_, err := NewConnect(fixedHeader, Version311, buf)
+			if tt.err == nil {
+				assert.NoError(t, err, name)
+			} else {
+				assert.ErrorIs(t, err, tt.err, name)
+			}
+		}
+	})
+
 	t.Run(" Connect Flags error", func(t *testing.T) {
 		fixedHeader := &FixedHeader{
 			PacketType:   CONNECT,
@@ -312,6 +353,23 @@ func TestConnect_Encode(t *testing.T) {
 	err = connect.Encode(buffer)
 	assert.NoError(t, err)
 	assert.NotNil(t, buffer)
+
+	t.Run("v31", func(t *testing.T) {
+		connect := &Connect{
+			FixedHeader:   &FixedHeader{PacketType: CONNECT, Flags: FixedHeaderFlagReserved},
+			Version:       Version31,
+			ProtocolName:  []byte("MQIsdp"),
+			ProtocolLevel: byte(Version31),
+			ConnectFlags:  ConnectFlags{CleanSession: true},
+			ClientId:      []byte("t"),
+		}
+		buffer := &bytes.Buffer{}
+		assert.NoError(t, connect.Encode(buffer))
+		p, err := NewReader(buffer).Read()
+		assert.NoError(t, err)
+		assert.Equal(t, Version31, p.(*Connect).Version)
+		assert.Equal(t, []byte("MQIsdp"), p.(*Connect).ProtocolName)
+	})
 }
 
 func TestConnect_String(t *testing.T) {
@@ -379,3 +437,47 @@ func TestConnect_String(t *testing.T) {
 		})
 	}
 }
+
+func TestConnect_V5WillProperties(t *testing.T) {
+	connectBytes := []byte{
+		0x00, 0x04, 'M', 'Q', 'T', 'T', // Protocol name
+		0x05,      // Protocol Level
+		0x04,      // Connect Flags
+		0x0, 0x02, // Keep Alive
+		0x05, 0x11, 0x00, 0x00, 0x00, 0x0a, // Properties
+		0x00, 0x01, 't', // Client Identifier
+		0x0a, 0x18, 0x00, 0x00, 0x00, 0x05, 0x03, 0x00, 0x02, 'a', 'b', // Will Properties
+		0x00, 0x01, 'a', // Will Topic
+		0x00, 0x01, 'b', // Will Message
+	}
+	fixedHeader := &FixedHeader{
+		PacketType:   CONNECT,
+		Flags:        FixedHeaderFlagReserved,
+		RemainLength: len(connectBytes),
+	}
+	connect, err := NewConnect(fixedHeader, Version5, bytes.NewBuffer(connectBytes))
+	if assert.NoError(t, err) && assert.NotNil(t, connect.WillProperties) {
+		assert.EqualValues(t, 5, *connect.WillProperties.WillDelayInterval)
+		assert.Equal(t, []byte("ab"), connect.WillProperties.ContentType)
+		assert.Equal(t, []byte("t"), connect.ClientId)
+		assert.Equal(t, []byte("a"), connect.WillTopic)
+		assert.Equal(t, []byte("b"), connect.WillMessage)
+	}
+
+	buf := &bytes.Buffer{}
+	connect.FixedHeader = &FixedHeader{PacketType: CONNECT, Flags: FixedHeaderFlagReserved}
+	assert.NoError(t, connect.Encode(buf))
+	reader := NewReader(buf)
+	p, err := reader.Read()
+	if assert.NoError(t, err) {
+		assert.Equal(t, connect.WillProperties, p.(*Connect).WillProperties)
+	}
+
+	t.Run("malformed will properties", func(t *testing.T) {
+		b := append([]byte{}, connectBytes...)
+		// Will Delay Interval with 3 bytes
+		b[19] = 0x04
+		_, err := NewConnect(&FixedHeader{PacketType: CONNECT, Flags: FixedHeaderFlagReserved, RemainLength: len(b)}, Version5, bytes.NewBuffer(b))
+		assert.ErrorIs(t, err, xerror.ErrMalformed)
+	})
+}
@@ -17,7 +17,9 @@
 package packet
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -26,6 +28,10 @@ type (
 	Disconnect struct {
 		Version     Version
 		FixedHeader *FixedHeader
+		// Code is the Disconnect Reason Code, only available in v5.
+		Code code.Code
+		// Properties is the disconnect properties, only available in v5.
+		Properties *Properties
 	}
 )
 
@@ -35,7 +41,6 @@ func NewDisconnect(fixedHeader *FixedHeader, version Version, r io.Reader) (*Dis
 		return nil, xerror.ErrMalformed
 	}
 	p := &Disconnect{FixedHeader: fixedHeader, Version: version}
-	p.FixedHeader.RemainLength = 0
 	err := p.Decode(r)
 	if err != nil {
 		return nil, err
@@ -45,13 +50,50 @@ func NewDisconnect(fixedHeader *FixedHeader, version Version, r io.Reader) (*Dis
 
 func (d *Disconnect) Encode(w io.Writer) (err error) {
 	d.FixedHeader = &FixedHeader{PacketType: DISCONNECT, Flags: FixedHeaderFlagReserved}
+	if IsVersion5(d.Version) {
+		buf := &bytes.Buffer{}
+		buf.WriteByte(d.Code)
+		if err = d.Properties.Pack(buf); err != nil {
+			return err
+		}
+		return encode(d.FixedHeader, buf, w)
+	}
 	return d.FixedHeader.Encode(w)
 }
 
-func (d *Disconnect) Decode(_ io.Reader) (err error) {
-	return
+func (d *Disconnect) Decode(r io.Reader) (err error) {
+	if IsVersion3(d.Version) {
+		// The DISCONNECT packet has no variable header and no payload in v3.
+		if d.FixedHeader.RemainLength != 0 {
+			return xerror.ErrMalformed
+		}
+		return
+	}
+	// The Reason Code and Property Length can be omitted if the Reason Code is 0x00 (Normal disconnecton) and there are no Properties.
+	if d.FixedHeader.RemainLength == 0 {
+		d.Code = code.NormalDisconnection
+		return
+	}
+	restBuffer := make([]byte, d.FixedHeader.RemainLength)
+	_, err = io.ReadFull(r, restBuffer)
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	buf := bytes.NewBuffer(restBuffer)
+	d.Code, err = buf.ReadByte()
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	d.Properties, err = unpackProperties(buf, DISCONNECT)
+	return err
 }
 
 func (d *Disconnect) String() string {
+	if IsVersion5(d.Version) {
+		return fmt.Sprintf("Disconnect - Version: %s, Code: %v, Properties: %s", d.Version, d.Code, d.Properties)
+	}
 	return fmt.Sprintf("Disconnect - Version: %s", d.Version)
 }
@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"testing"
 )
@@ -64,6 +65,12 @@ func TestDisconnect_Encode(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, []byte{0xe0, 0x0}, buffer.Bytes())
 	})
+	t.Run("v5", func(t *testing.T) {
+		disconnect := &Disconnect{Version: Version5, Code: code.SessionTakenOver}
+		buffer := &bytes.Buffer{}
+		assert.NoError(t, disconnect.Encode(buffer))
+		assert.Equal(t, []byte{0xe0, 0x02, 0x8e, 0x00}, buffer.Bytes())
+	})
 }
 func TestDisconnect_String(t *testing.T) {
 	fixedHeader := &FixedHeader{
@@ -77,3 +84,29 @@ func TestDisconnect_String(t *testing.T) {
 	assert.NotNil(t, disconnect)
 	assert.Equal(t, "Disconnect - Version: MQTT3.1.1", disconnect.String())
 }
+
+func TestDisconnect_V5(t *testing.T) {
+	var delay uint32 = 5
+	disconnect := &Disconnect{
+		Version: Version5,
+		Code:    code.DisconnectWithWillMessage,
+		Properties: &Properties{
+			SessionExpiryInterval: &delay,
+		},
+	}
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, disconnect.Encode(buffer))
+
+	reader := NewReader(buffer)
+	reader.version = Version5
+	p, err := reader.Read()
+	assert.NoError(t, err)
+	got := p.(*Disconnect)
+	assert.Equal(t, code.DisconnectWithWillMessage, got.Code)
+	assert.Equal(t, disconnect.Properties, got.Properties)
+
+	// The Reason Code and Property Length can be omitted.
+	got, err = NewDisconnect(&FixedHeader{PacketType: DISCONNECT}, Version5, &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.Equal(t, code.NormalDisconnection, got.Code)
+}
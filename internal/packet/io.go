@@ -18,6 +18,7 @@ package packet
 
 import (
 	"bufio"
+	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
 
@@ -26,6 +27,8 @@ type (
 	Reader struct {
 		buf     *bufio.Reader
 		version Version
+		// maxPacketSize is the maximum packet size allowed to read, 0 means no limit.
+		maxPacketSize uint32
 	}
 	// Writer is used to encode MQTT packet into bytes and write it to bufio.Writer.
 	Writer struct {
@@ -62,6 +65,10 @@ func (r *Reader) Read() (p Packet, err error) {
 	if err != nil {
 		return
 	}
+	// check the packet size before the packet is allocated.
+	if r.maxPacketSize != 0 && packetSize(fh.RemainLength) > r.maxPacketSize {
+		return nil, xerror.ErrPacketTooLarge
+	}
 
 	// packet
 	p, err = NewPacket(fh, r.version, r.buf)
@@ -74,6 +81,25 @@ func (r *Reader) Read() (p Packet, err error) {
 	return
 }
 
+// SetMaxPacketSize sets the maximum packet size allowed to read, 0 means no limit.
+// Read returns xerror.ErrPacketTooLarge if the packet exceeds the maximum packet size.
+func (r *Reader) SetMaxPacketSize(size uint32) {
+	r.maxPacketSize = size
+}
+
+// packetSize returns the total size of the packet, including the fixed header.
+func packetSize(remainLength int) uint32 {
+	switch {
+	case remainLength <= RemainLength1ByteMax:
+		return 2 + uint32(remainLength)
+	case remainLength <= RemainLength2ByteMax:
+		return 3 + uint32(remainLength)
+	case remainLength <= RemainLength3ByteMax:
+		return 4 + uint32(remainLength)
+	}
+	return 5 + uint32(remainLength)
+}
+
 // NewWriter returns a new Writer.
 func NewWriter(w io.Writer) *Writer {
 	if bufw, ok := w.(*bufio.Writer); ok {
@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
 	"testing"
 )
 
@@ -161,3 +162,28 @@ func TestWriter_WriteAndFlush(t *testing.T) {
 	}, buffer.Bytes())
 
 }
+
+func TestReader_SetMaxPacketSize(t *testing.T) {
+	a := assert.New(t)
+	pub := &Publish{Version: Version311, TopicName: []byte("a"), Payload: make([]byte, 200)}
+	buf := &bytes.Buffer{}
+	a.NoError(NewWriter(buf).WritePacketAndFlush(pub))
+	size := uint32(buf.Len())
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetMaxPacketSize(size - 1)
+	_, err := r.Read()
+	a.ErrorIs(err, xerror.ErrPacketTooLarge)
+
+	r = NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetMaxPacketSize(size)
+	p, err := r.Read()
+	a.NoError(err)
+	a.IsType(&Publish{}, p)
+
+	// the remaining length is checked before the packet is read.
+	r = NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0x7f}))
+	r.SetMaxPacketSize(1024)
+	_, err = r.Read()
+	a.ErrorIs(err, xerror.ErrPacketTooLarge)
+}
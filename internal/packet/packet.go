@@ -83,8 +83,8 @@ const (
 	PINGRESP
 	// DISCONNECT Client is disconnecting
 	DISCONNECT
-	// AUTHReserved Forbidden
-	AUTHReserved
+	// AUTH Authentication exchange, only available in v5
+	AUTH
 
 	// Flag in the FixHeader
 
@@ -323,13 +323,13 @@ func DecodeRemainLength(r io.ByteReader) (int, error) {
 			return 0, err
 		}
 		value += uint32(encodedByte&127) * multiplier
+		if (encodedByte & 128) == 0 {
+			break
+		}
 		multiplier *= 128
 		if multiplier > 128*128*128 {
 			return 0, xerror.ErrMalformed
 		}
-		if (encodedByte & 128) == 0 {
-			break
-		}
 	}
 	return int(value), nil
 }
@@ -361,10 +361,11 @@ func NewPacket(fixedHeader *FixedHeader, version Version, r io.Reader) (Packet,
 	case DISCONNECT:
 		return NewDisconnect(fixedHeader, version, r)
 	case UNSUBACK:
-		return NewSuback(fixedHeader, version, r)
+		return NewUnsuback(fixedHeader, version, r)
 	case PINGRESP:
 		return NewPingresp(fixedHeader, r)
-	//case AUTH:
+	case AUTH:
+		return NewAuth(fixedHeader, version, r)
 	default:
 		return nil, xerror.ErrProtocol
 	}
@@ -17,7 +17,9 @@
 package packet
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
 	"testing"
 )
 
@@ -32,3 +34,15 @@ func TestUTF8EncodedStrings(t *testing.T) {
 func TestNewPacket(t *testing.T) {
 
 }
+
+func TestDecodeRemainLength(t *testing.T) {
+	for _, length := range []int{0, RemainLength1ByteMax, RemainLength2ByteMax, RemainLength3ByteMax, RemainLength4ByteMax} {
+		b, err := EncodeRemainLength(length)
+		assert.NoError(t, err)
+		got, err := DecodeRemainLength(bytes.NewBuffer(b))
+		assert.NoError(t, err)
+		assert.Equal(t, length, got)
+	}
+	_, err := DecodeRemainLength(bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0x01}))
+	assert.ErrorIs(t, err, xerror.ErrMalformed)
+}
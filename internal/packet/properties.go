@@ -0,0 +1,579 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/yunqi/lighthouse/internal/xerror"
+)
+
+type (
+	// Properties is a struct representing the properties which can be used in MQTT v5 packets.
+	// A nil pointer or an empty slice means the property is absent.
+	// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901027
+	Properties struct {
+		// PayloadFormat indicates the format of the payload of the message.
+		// 0 is unspecified bytes, 1 is UTF-8 encoded character data
+		PayloadFormat *PayloadFormat
+		// MessageExpiry is the lifetime of the message in seconds.
+		MessageExpiry *uint32
+		// ContentType is a UTF-8 string describing the content of the message.
+		ContentType []byte
+		// ResponseTopic is the topic name for a response message.
+		ResponseTopic []byte
+		// CorrelationData is used by the sender of the request message to identify which request the response message is for.
+		CorrelationData []byte
+		// SubscriptionIdentifier is the identifier of the subscription to which the Publish matched.
+		SubscriptionIdentifier []uint32
+		// SessionExpiryInterval is the time in seconds after a client disconnects that the server should retain the session information.
+		SessionExpiryInterval *uint32
+		// AssignedClientID is the client id assigned by the server when the client connects with a zero length client id.
+		AssignedClientID []byte
+		// ServerKeepAlive is the keep alive time assigned by the server.
+		ServerKeepAlive *uint16
+		// AuthMethod is the name of the authentication method used for extended authentication.
+		AuthMethod []byte
+		// AuthData is the authentication data.
+		AuthData []byte
+		// RequestProblemInfo is used by the client to indicate to the server whether the Reason String or User Properties are sent in the case of failures.
+		RequestProblemInfo *byte
+		// WillDelayInterval is the number of seconds which the server waits before publishing the will message.
+		WillDelayInterval *uint32
+		// RequestResponseInfo is used by the client to request the server to return Response Information in the CONNACK.
+		RequestResponseInfo *byte
+		// ResponseInfo is used as the basis for creating a Response Topic.
+		ResponseInfo []byte
+		// ServerReference is used by the client to identify another server to use.
+		ServerReference []byte
+		// ReasonString is a human readable string designed for diagnostics.
+		ReasonString []byte
+		// ReceiveMaximum is the number of QoS 1 and QoS 2 publications that the sender is willing to process concurrently.
+		ReceiveMaximum *uint16
+		// TopicAliasMaximum is the highest value that the sender will accept as a Topic Alias.
+		TopicAliasMaximum *uint16
+		// TopicAlias is a value that is used to identify the topic instead of using the topic name.
+		TopicAlias *uint16
+		// MaximumQoS is the maximum QoS the server supports.
+		MaximumQoS *byte
+		// RetainAvailable declares whether the server supports retained messages.
+		RetainAvailable *byte
+		// User is the user property which is a name-value pair.
+		User []UserProperty
+		// MaximumPacketSize is the maximum packet size the sender is willing to accept.
+		MaximumPacketSize *uint32
+		// WildcardSubAvailable declares whether the server supports wildcard subscriptions.
+		WildcardSubAvailable *byte
+		// SubIDAvailable declares whether the server supports subscription identifiers.
+		SubIDAvailable *byte
+		// SharedSubAvailable declares whether the server supports shared subscriptions.
+		SharedSubAvailable *byte
+	}
+	// UserProperty is a UTF-8 string pair.
+	UserProperty struct {
+		K []byte
+		V []byte
+	}
+)
+
+func (p *Properties) String() string {
+	if p == nil {
+		return "nil"
+	}
+	var buf bytes.Buffer
+	if p.PayloadFormat != nil {
+		buf.WriteString(fmt.Sprintf("PayloadFormat: %d, ", *p.PayloadFormat))
+	}
+	if p.MessageExpiry != nil {
+		buf.WriteString(fmt.Sprintf("MessageExpiry: %d, ", *p.MessageExpiry))
+	}
+	if p.ContentType != nil {
+		buf.WriteString(fmt.Sprintf("ContentType: %s, ", p.ContentType))
+	}
+	if p.ResponseTopic != nil {
+		buf.WriteString(fmt.Sprintf("ResponseTopic: %s, ", p.ResponseTopic))
+	}
+	if p.CorrelationData != nil {
+		buf.WriteString(fmt.Sprintf("CorrelationData: %v, ", p.CorrelationData))
+	}
+	if p.SubscriptionIdentifier != nil {
+		buf.WriteString(fmt.Sprintf("SubscriptionIdentifier: %v, ", p.SubscriptionIdentifier))
+	}
+	if p.SessionExpiryInterval != nil {
+		buf.WriteString(fmt.Sprintf("SessionExpiryInterval: %d, ", *p.SessionExpiryInterval))
+	}
+	if p.AssignedClientID != nil {
+		buf.WriteString(fmt.Sprintf("AssignedClientID: %s, ", p.AssignedClientID))
+	}
+	if p.ServerKeepAlive != nil {
+		buf.WriteString(fmt.Sprintf("ServerKeepAlive: %d, ", *p.ServerKeepAlive))
+	}
+	if p.AuthMethod != nil {
+		buf.WriteString(fmt.Sprintf("AuthMethod: %s, ", p.AuthMethod))
+	}
+	if p.AuthData != nil {
+		buf.WriteString(fmt.Sprintf("AuthData: %v, ", p.AuthData))
+	}
+	if p.RequestProblemInfo != nil {
+		buf.WriteString(fmt.Sprintf("RequestProblemInfo: %d, ", *p.RequestProblemInfo))
+	}
+	if p.WillDelayInterval != nil {
+		buf.WriteString(fmt.Sprintf("WillDelayInterval: %d, ", *p.WillDelayInterval))
+	}
+	if p.RequestResponseInfo != nil {
+		buf.WriteString(fmt.Sprintf("RequestResponseInfo: %d, ", *p.RequestResponseInfo))
+	}
+	if p.ResponseInfo != nil {
+		buf.WriteString(fmt.Sprintf("ResponseInfo: %s, ", p.ResponseInfo))
+	}
+	if p.ServerReference != nil {
+		buf.WriteString(fmt.Sprintf("ServerReference: %s, ", p.ServerReference))
+	}
+	if p.ReasonString != nil {
+		buf.WriteString(fmt.Sprintf("ReasonString: %s, ", p.ReasonString))
+	}
+	if p.ReceiveMaximum != nil {
+		buf.WriteString(fmt.Sprintf("ReceiveMaximum: %d, ", *p.ReceiveMaximum))
+	}
+	if p.TopicAliasMaximum != nil {
+		buf.WriteString(fmt.Sprintf("TopicAliasMaximum: %d, ", *p.TopicAliasMaximum))
+	}
+	if p.TopicAlias != nil {
+		buf.WriteString(fmt.Sprintf("TopicAlias: %d, ", *p.TopicAlias))
+	}
+	if p.MaximumQoS != nil {
+		buf.WriteString(fmt.Sprintf("MaximumQoS: %d, ", *p.MaximumQoS))
+	}
+	if p.RetainAvailable != nil {
+		buf.WriteString(fmt.Sprintf("RetainAvailable: %d, ", *p.RetainAvailable))
+	}
+	for _, v := range p.User {
+		buf.WriteString(fmt.Sprintf("User: %s=%s, ", v.K, v.V))
+	}
+	if p.MaximumPacketSize != nil {
+		buf.WriteString(fmt.Sprintf("MaximumPacketSize: %d, ", *p.MaximumPacketSize))
+	}
+	if p.WildcardSubAvailable != nil {
+		buf.WriteString(fmt.Sprintf("WildcardSubAvailable: %d, ", *p.WildcardSubAvailable))
+	}
+	if p.SubIDAvailable != nil {
+		buf.WriteString(fmt.Sprintf("SubIDAvailable: %d, ", *p.SubIDAvailable))
+	}
+	if p.SharedSubAvailable != nil {
+		buf.WriteString(fmt.Sprintf("SharedSubAvailable: %d, ", *p.SharedSubAvailable))
+	}
+	return buf.String()
+}
+
+// Pack encodes the properties (including the property length) into bytes and writes it into w.
+// A nil Properties is encoded as zero property length.
+func (p *Properties) Pack(w *bytes.Buffer) error {
+	if p == nil {
+		w.WriteByte(0)
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if p.PayloadFormat != nil {
+		propertyWriteByte(buf, PropPayloadFormat, *p.PayloadFormat)
+	}
+	if p.MessageExpiry != nil {
+		propertyWriteUint32(buf, PropMessageExpiry, *p.MessageExpiry)
+	}
+	if err := propertyWriteBinary(buf, PropContentType, p.ContentType); err != nil {
+		return err
+	}
+	if err := propertyWriteBinary(buf, PropResponseTopic, p.ResponseTopic); err != nil {
+		return err
+	}
+	if err := propertyWriteBinary(buf, PropCorrelationData, p.CorrelationData); err != nil {
+		return err
+	}
+	for _, v := range p.SubscriptionIdentifier {
+		b, err := EncodeRemainLength(int(v))
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(PropSubscriptionIdentifier)
+		buf.Write(b)
+	}
+	if p.SessionExpiryInterval != nil {
+		propertyWriteUint32(buf, PropSessionExpiryInterval, *p.SessionExpiryInterval)
+	}
+	if err := propertyWriteBinary(buf, PropAssignedClientID, p.AssignedClientID); err != nil {
+		return err
+	}
+	if p.ServerKeepAlive != nil {
+		propertyWriteUint16(buf, PropServerKeepAlive, *p.ServerKeepAlive)
+	}
+	if err := propertyWriteBinary(buf, PropAuthMethod, p.AuthMethod); err != nil {
+		return err
+	}
+	if err := propertyWriteBinary(buf, PropAuthData, p.AuthData); err != nil {
+		return err
+	}
+	if p.RequestProblemInfo != nil {
+		propertyWriteByte(buf, PropRequestProblemInfo, *p.RequestProblemInfo)
+	}
+	if p.WillDelayInterval != nil {
+		propertyWriteUint32(buf, PropWillDelayInterval, *p.WillDelayInterval)
+	}
+	if p.RequestResponseInfo != nil {
+		propertyWriteByte(buf, PropRequestResponseInfo, *p.RequestResponseInfo)
+	}
+	if err := propertyWriteBinary(buf, PropResponseInfo, p.ResponseInfo); err != nil {
+		return err
+	}
+	if err := propertyWriteBinary(buf, PropServerReference, p.ServerReference); err != nil {
+		return err
+	}
+	if err := propertyWriteBinary(buf, PropReasonString, p.ReasonString); err != nil {
+		return err
+	}
+	if p.ReceiveMaximum != nil {
+		propertyWriteUint16(buf, PropReceiveMaximum, *p.ReceiveMaximum)
+	}
+	if p.TopicAliasMaximum != nil {
+		propertyWriteUint16(buf, PropTopicAliasMaximum, *p.TopicAliasMaximum)
+	}
+	if p.TopicAlias != nil {
+		propertyWriteUint16(buf, PropTopicAlias, *p.TopicAlias)
+	}
+	if p.MaximumQoS != nil {
+		propertyWriteByte(buf, PropMaximumQOS, *p.MaximumQoS)
+	}
+	if p.RetainAvailable != nil {
+		propertyWriteByte(buf, PropRetainAvailable, *p.RetainAvailable)
+	}
+	for _, v := range p.User {
+		k, _, err := UTF8EncodedStrings(v.K)
+		if err != nil {
+			return err
+		}
+		val, _, err := UTF8EncodedStrings(v.V)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(PropUser)
+		buf.Write(k)
+		buf.Write(val)
+	}
+	if p.MaximumPacketSize != nil {
+		propertyWriteUint32(buf, PropMaximumPacketSize, *p.MaximumPacketSize)
+	}
+	if p.WildcardSubAvailable != nil {
+		propertyWriteByte(buf, PropWildcardSubAvailable, *p.WildcardSubAvailable)
+	}
+	if p.SubIDAvailable != nil {
+		propertyWriteByte(buf, PropSubIDAvailable, *p.SubIDAvailable)
+	}
+	if p.SharedSubAvailable != nil {
+		propertyWriteByte(buf, PropSharedSubAvailable, *p.SharedSubAvailable)
+	}
+	length, err := EncodeRemainLength(buf.Len())
+	if err != nil {
+		return err
+	}
+	w.Write(length)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Unpack reads the property length and the properties from r.
+func (p *Properties) Unpack(r *bytes.Buffer) error {
+	length, err := DecodeRemainLength(r)
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	if length == 0 {
+		return nil
+	}
+	if r.Len() < length {
+		return xerror.ErrMalformed
+	}
+	buf := bytes.NewBuffer(r.Next(length))
+	// A property MUST NOT be included more than once, except User Property and Subscription Identifier. [MQTT-2.2.2-2]
+	seen := make(map[byte]struct{})
+	for buf.Len() > 0 {
+		propType, err := buf.ReadByte()
+		if err != nil {
+			return xerror.ErrMalformed
+		}
+		if propType != PropUser && propType != PropSubscriptionIdentifier {
+			if _, ok := seen[propType]; ok {
+				return xerror.ErrProtocol
+			}
+			seen[propType] = struct{}{}
+		}
+		switch propType {
+		case PropPayloadFormat:
+			p.PayloadFormat, err = propertyReadBool(buf)
+		case PropMessageExpiry:
+			p.MessageExpiry, err = propertyReadUint32(buf)
+		case PropContentType:
+			p.ContentType, err = UTF8DecodedStrings(true, buf)
+		case PropResponseTopic:
+			p.ResponseTopic, err = UTF8DecodedStrings(true, buf)
+		case PropCorrelationData:
+			p.CorrelationData, err = UTF8DecodedStrings(false, buf)
+		case PropSubscriptionIdentifier:
+			var id int
+			id, err = DecodeRemainLength(buf)
+			if err == nil && id == 0 {
+				// It is a Protocol Error if the Subscription Identifier has a value of 0.
+				err = xerror.ErrProtocol
+			}
+			p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, uint32(id))
+		case PropSessionExpiryInterval:
+			p.SessionExpiryInterval, err = propertyReadUint32(buf)
+		case PropAssignedClientID:
+			p.AssignedClientID, err = UTF8DecodedStrings(true, buf)
+		case PropServerKeepAlive:
+			p.ServerKeepAlive, err = propertyReadUint16(buf)
+		case PropAuthMethod:
+			p.AuthMethod, err = UTF8DecodedStrings(true, buf)
+		case PropAuthData:
+			p.AuthData, err = UTF8DecodedStrings(false, buf)
+		case PropRequestProblemInfo:
+			p.RequestProblemInfo, err = propertyReadBool(buf)
+		case PropWillDelayInterval:
+			p.WillDelayInterval, err = propertyReadUint32(buf)
+		case PropRequestResponseInfo:
+			p.RequestResponseInfo, err = propertyReadBool(buf)
+		case PropResponseInfo:
+			p.ResponseInfo, err = UTF8DecodedStrings(true, buf)
+		case PropServerReference:
+			p.ServerReference, err = UTF8DecodedStrings(true, buf)
+		case PropReasonString:
+			p.ReasonString, err = UTF8DecodedStrings(true, buf)
+		case PropReceiveMaximum:
+			p.ReceiveMaximum, err = propertyReadUint16(buf)
+			if err == nil && *p.ReceiveMaximum == 0 {
+				// It is a Protocol Error to include the Receive Maximum value more than once or for it to have the value 0.
+				err = xerror.ErrProtocol
+			}
+		case PropTopicAliasMaximum:
+			p.TopicAliasMaximum, err = propertyReadUint16(buf)
+		case PropTopicAlias:
+			p.TopicAlias, err = propertyReadUint16(buf)
+		case PropMaximumQOS:
+			p.MaximumQoS, err = propertyReadBool(buf)
+		case PropRetainAvailable:
+			p.RetainAvailable, err = propertyReadBool(buf)
+		case PropUser:
+			var k, v []byte
+			k, err = UTF8DecodedStrings(true, buf)
+			if err != nil {
+				return err
+			}
+			v, err = UTF8DecodedStrings(true, buf)
+			p.User = append(p.User, UserProperty{K: k, V: v})
+		case PropMaximumPacketSize:
+			p.MaximumPacketSize, err = propertyReadUint32(buf)
+			if err == nil && *p.MaximumPacketSize == 0 {
+				// It is a Protocol Error for the value to be set to zero.
+				err = xerror.ErrProtocol
+			}
+		case PropWildcardSubAvailable:
+			p.WildcardSubAvailable, err = propertyReadBool(buf)
+		case PropSubIDAvailable:
+			p.SubIDAvailable, err = propertyReadBool(buf)
+		case PropSharedSubAvailable:
+			p.SharedSubAvailable, err = propertyReadBool(buf)
+		default:
+			return xerror.ErrMalformed
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propertiesWill is the pseudo packet type of the will properties in CONNECT.
+const propertiesWill byte = 0xff
+
+// propertyPacketTypes stores the packet types in which each property can be used.
+// The User Property can be used in all packet types.
+var propertyPacketTypes = map[byte][]byte{
+	PropPayloadFormat:          {PUBLISH, propertiesWill},
+	PropMessageExpiry:          {PUBLISH, propertiesWill},
+	PropContentType:            {PUBLISH, propertiesWill},
+	PropResponseTopic:          {PUBLISH, propertiesWill},
+	PropCorrelationData:        {PUBLISH, propertiesWill},
+	PropSubscriptionIdentifier: {PUBLISH, SUBSCRIBE},
+	PropSessionExpiryInterval:  {CONNECT, CONNACK, DISCONNECT},
+	PropAssignedClientID:       {CONNACK},
+	PropServerKeepAlive:        {CONNACK},
+	PropAuthMethod:             {CONNECT, CONNACK, AUTH},
+	PropAuthData:               {CONNECT, CONNACK, AUTH},
+	PropRequestProblemInfo:     {CONNECT},
+	PropWillDelayInterval:      {propertiesWill},
+	PropRequestResponseInfo:    {CONNECT},
+	PropResponseInfo:           {CONNACK},
+	PropServerReference:        {CONNACK, DISCONNECT},
+	PropReasonString:           {CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT, AUTH},
+	PropReceiveMaximum:         {CONNECT, CONNACK},
+	PropTopicAliasMaximum:      {CONNECT, CONNACK},
+	PropTopicAlias:             {PUBLISH},
+	PropMaximumQOS:             {CONNACK},
+	PropRetainAvailable:        {CONNACK},
+	PropMaximumPacketSize:      {CONNECT, CONNACK},
+	PropWildcardSubAvailable:   {CONNACK},
+	PropSubIDAvailable:         {CONNACK},
+	PropSharedSubAvailable:     {CONNACK},
+}
+
+// unpackProperties reads the properties of the packet type from r.
+// It is a Protocol Error to include a property which is not allowed in the packet type.
+func unpackProperties(r *bytes.Buffer, packetType byte) (*Properties, error) {
+	p := &Properties{}
+	if err := p.Unpack(r); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(packetType); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate returns xerror.ErrProtocol if the properties contains any property which is not allowed in the packet type.
+func (p *Properties) Validate(packetType byte) error {
+	if p == nil {
+		return nil
+	}
+	for _, propType := range p.present() {
+		allowed := false
+		for _, t := range propertyPacketTypes[propType] {
+			if t == packetType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return xerror.ErrProtocol
+		}
+	}
+	return nil
+}
+
+// present returns the identifiers of the present properties, except the User Property.
+func (p *Properties) present() []byte {
+	var ids []byte
+	add := func(ok bool, propType byte) {
+		if ok {
+			ids = append(ids, propType)
+		}
+	}
+	add(p.PayloadFormat != nil, PropPayloadFormat)
+	add(p.MessageExpiry != nil, PropMessageExpiry)
+	add(p.ContentType != nil, PropContentType)
+	add(p.ResponseTopic != nil, PropResponseTopic)
+	add(p.CorrelationData != nil, PropCorrelationData)
+	add(len(p.SubscriptionIdentifier) != 0, PropSubscriptionIdentifier)
+	add(p.SessionExpiryInterval != nil, PropSessionExpiryInterval)
+	add(p.AssignedClientID != nil, PropAssignedClientID)
+	add(p.ServerKeepAlive != nil, PropServerKeepAlive)
+	add(p.AuthMethod != nil, PropAuthMethod)
+	add(p.AuthData != nil, PropAuthData)
+	add(p.RequestProblemInfo != nil, PropRequestProblemInfo)
+	add(p.WillDelayInterval != nil, PropWillDelayInterval)
+	add(p.RequestResponseInfo != nil, PropRequestResponseInfo)
+	add(p.ResponseInfo != nil, PropResponseInfo)
+	add(p.ServerReference != nil, PropServerReference)
+	add(p.ReasonString != nil, PropReasonString)
+	add(p.ReceiveMaximum != nil, PropReceiveMaximum)
+	add(p.TopicAliasMaximum != nil, PropTopicAliasMaximum)
+	add(p.TopicAlias != nil, PropTopicAlias)
+	add(p.MaximumQoS != nil, PropMaximumQOS)
+	add(p.RetainAvailable != nil, PropRetainAvailable)
+	add(p.MaximumPacketSize != nil, PropMaximumPacketSize)
+	add(p.WildcardSubAvailable != nil, PropWildcardSubAvailable)
+	add(p.SubIDAvailable != nil, PropSubIDAvailable)
+	add(p.SharedSubAvailable != nil, PropSharedSubAvailable)
+	return ids
+}
+
+func propertyWriteByte(w *bytes.Buffer, propType byte, value byte) {
+	w.WriteByte(propType)
+	w.WriteByte(value)
+}
+
+func propertyWriteUint16(w *bytes.Buffer, propType byte, value uint16) {
+	w.WriteByte(propType)
+	writeUint16(w, value)
+}
+
+func propertyWriteUint32(w *bytes.Buffer, propType byte, value uint32) {
+	w.WriteByte(propType)
+	writeUint32(w, value)
+}
+
+func propertyWriteBinary(w *bytes.Buffer, propType byte, value []byte) error {
+	if value == nil {
+		return nil
+	}
+	b, _, err := UTF8EncodedStrings(value)
+	if err != nil {
+		return err
+	}
+	w.WriteByte(propType)
+	w.Write(b)
+	return nil
+}
+
+// propertyReadBool reads a byte property whose value must be 0 or 1.
+func propertyReadBool(r *bytes.Buffer) (*byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, xerror.ErrMalformed
+	}
+	if b > 1 {
+		return nil, xerror.ErrProtocol
+	}
+	return &b, nil
+}
+
+func propertyReadUint16(r *bytes.Buffer) (*uint16, error) {
+	v, err := readUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func propertyReadUint32(r *bytes.Buffer) (*uint32, error) {
+	v, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func writeUint32(w *bytes.Buffer, value uint32) {
+	w.WriteByte(byte(value >> 24))
+	w.WriteByte(byte(value >> 16))
+	w.WriteByte(byte(value >> 8))
+	w.WriteByte(byte(value))
+}
+
+func readUint32(r *bytes.Buffer) (uint32, error) {
+	if r.Len() < 4 {
+		return 0, xerror.ErrMalformed
+	}
+	return binary.BigEndian.Uint32(r.Next(4)), nil
+}
@@ -0,0 +1,121 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func TestProperties_PackAndUnpack(t *testing.T) {
+	var (
+		u32 uint32 = 10
+		u16 uint16 = 20
+		b   byte   = 1
+	)
+	props := &Properties{
+		PayloadFormat:          &b,
+		MessageExpiry:          &u32,
+		ContentType:            []byte("json"),
+		ResponseTopic:          []byte("a/b"),
+		CorrelationData:        []byte{1, 2, 3},
+		SubscriptionIdentifier: []uint32{1, 268435455},
+		SessionExpiryInterval:  &u32,
+		ServerKeepAlive:        &u16,
+		WillDelayInterval:      &u32,
+		ReceiveMaximum:         &u16,
+		TopicAliasMaximum:      &u16,
+		User: []UserProperty{
+			{K: []byte("k1"), V: []byte("v1")},
+			{K: []byte("k1"), V: []byte("v2")},
+		},
+		MaximumPacketSize: &u32,
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, props.Pack(buf))
+
+	got := &Properties{}
+	assert.NoError(t, got.Unpack(buf))
+	assert.Equal(t, props, got)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestProperties_Unpack(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, (*Properties)(nil).Pack(buf))
+		assert.Equal(t, []byte{0}, buf.Bytes())
+		props := &Properties{}
+		assert.NoError(t, props.Unpack(buf))
+		assert.Equal(t, &Properties{}, props)
+	})
+	t.Run("duplicate property", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{10, PropWillDelayInterval, 0, 0, 0, 1, PropWillDelayInterval, 0, 0, 0, 1})
+		assert.ErrorIs(t, (&Properties{}).Unpack(buf), xerror.ErrProtocol)
+	})
+	t.Run("invalid property", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{2, 0xff, 0})
+		assert.ErrorIs(t, (&Properties{}).Unpack(buf), xerror.ErrMalformed)
+	})
+	t.Run("zero receive maximum", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{3, PropReceiveMaximum, 0, 0})
+		assert.ErrorIs(t, (&Properties{}).Unpack(buf), xerror.ErrProtocol)
+	})
+	t.Run("length exceeds", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{5, PropPayloadFormat, 1})
+		assert.ErrorIs(t, (&Properties{}).Unpack(buf), xerror.ErrMalformed)
+	})
+}
+
+func TestProperties_Validate(t *testing.T) {
+	u32 := uint32(1)
+	props := &Properties{
+		WillDelayInterval: &u32,
+		User:              []UserProperty{{K: []byte("k"), V: []byte("v")}},
+	}
+	assert.NoError(t, props.Validate(propertiesWill))
+	assert.ErrorIs(t, props.Validate(CONNECT), xerror.ErrProtocol)
+	assert.NoError(t, (&Properties{User: props.User}).Validate(PUBACK))
+	assert.NoError(t, (*Properties)(nil).Validate(PUBLISH))
+
+	t.Run("disallowed property", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, (&Properties{SessionExpiryInterval: &u32}).Pack(buf))
+		_, err := unpackProperties(buf, PUBLISH)
+		assert.ErrorIs(t, err, xerror.ErrProtocol)
+	})
+}
+
+func TestConnack_V5Properties(t *testing.T) {
+	max := uint16(10)
+	connack := &Connack{
+		Version:    Version5,
+		Code:       0,
+		Properties: &Properties{ReceiveMaximum: &max, AssignedClientID: []byte("id")},
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, connack.Encode(buf))
+	reader := NewReader(buf)
+	reader.version = Version5
+	p, err := reader.Read()
+	assert.NoError(t, err)
+	if got, ok := p.(*Connack); assert.True(t, ok) {
+		assert.Equal(t, connack.Properties, got.Properties)
+	}
+}
@@ -18,6 +18,7 @@ package packet
 
 import (
 	"bytes"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -42,3 +43,34 @@ func (bp *BasePub) decode(r io.Reader) (err error) {
 	return
 
 }
+
+// writeAckReason writes the reason code and the properties of PUBACK, PUBREC, PUBREL and PUBCOMP in v5.
+// The Reason Code and Property Length can be omitted if the Reason Code is 0x00 (Success) and there are no Properties.
+func writeAckReason(w *bytes.Buffer, version Version, cd code.Code, properties *Properties) error {
+	if !IsVersion5(version) || (cd == code.Success && properties == nil) {
+		return nil
+	}
+	w.WriteByte(cd)
+	if properties == nil {
+		return nil
+	}
+	return properties.Pack(w)
+}
+
+// readAckReason reads the reason code and the properties of PUBACK, PUBREC, PUBREL and PUBCOMP in v5.
+// If the Remaining Length is 2, the Reason Code 0x00 (Success) is used.
+// If the Remaining Length is less than 4 there is no Property Length.
+func readAckReason(r *bytes.Buffer, version Version, packetType byte) (cd code.Code, properties *Properties, err error) {
+	if !IsVersion5(version) || r.Len() == 0 {
+		return code.Success, nil, nil
+	}
+	cd, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, xerror.ErrMalformed
+	}
+	if r.Len() == 0 {
+		return cd, nil, nil
+	}
+	properties, err = unpackProperties(r, packetType)
+	return
+}
@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -32,6 +33,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the reason code, only available in v5.
+		Code code.Code
+		// Properties is the properties, only available in v5.
+		Properties *Properties
 	}
 )
 
@@ -52,6 +57,9 @@ func (bp *Puback) Encode(w io.Writer) (err error) {
 	bp.FixedHeader = pubackDefaultFixedHeader
 	buf := &bytes.Buffer{}
 	writeUint16(buf, bp.PacketId)
+	if err = writeAckReason(buf, bp.Version, bp.Code, bp.Properties); err != nil {
+		return err
+	}
 	return encode(bp.FixedHeader, buf, w)
 }
 
@@ -66,6 +74,7 @@ func (bp *Puback) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	bp.Code, bp.Properties, err = readAckReason(buf, bp.Version, PUBACK)
 	return
 }
 
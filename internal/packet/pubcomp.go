@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -28,6 +29,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the reason code, only available in v5.
+		Code code.Code
+		// Properties is the properties, only available in v5.
+		Properties *Properties
 	}
 )
 
@@ -47,6 +52,9 @@ func (pb *Pubcomp) Encode(w io.Writer) (err error) {
 	pb.FixedHeader = &FixedHeader{PacketType: PUBCOMP, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, pb.PacketId)
+	if err = writeAckReason(buf, pb.Version, pb.Code, pb.Properties); err != nil {
+		return err
+	}
 	return encode(pb.FixedHeader, buf, w)
 }
 
@@ -61,6 +69,7 @@ func (pb *Pubcomp) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	pb.Code, pb.Properties, err = readAckReason(buf, pb.Version, PUBCOMP)
 	return
 }
 
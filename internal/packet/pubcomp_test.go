@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"testing"
 )
@@ -61,3 +62,27 @@ func TestPubcomp_String(t *testing.T) {
 	assert.NotNil(t, puback)
 	assert.Equal(t, "Pubcomp - Version: MQTT3.1.1, PacketId: 0", puback.String())
 }
+
+func TestPubcomp_V5ReasonCode(t *testing.T) {
+	t.Run("success omits reason code", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		assert.NoError(t, (&Pubcomp{Version: Version5, PacketId: 1}).Encode(buff))
+		assert.Equal(t, []byte{0x70, 0x02, 0x00, 0x01}, buff.Bytes())
+	})
+	t.Run("packet id not found", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		assert.NoError(t, (&Pubcomp{Version: Version5, PacketId: 1, Code: code.PacketIDNotFound}).Encode(buff))
+		assert.Equal(t, []byte{0x70, 0x03, 0x00, 0x01, code.PacketIDNotFound}, buff.Bytes())
+
+		fixedHeader := &FixedHeader{PacketType: PUBCOMP, Flags: FixedHeaderFlagReserved, RemainLength: 3}
+		pubcomp, err := NewPubcomp(fixedHeader, Version5, bytes.NewBuffer(buff.Bytes()[2:]))
+		assert.NoError(t, err)
+		assert.Equal(t, code.PacketIDNotFound, pubcomp.Code)
+		assert.Nil(t, pubcomp.Properties)
+	})
+	t.Run("v3 ignores reason code", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		assert.NoError(t, (&Pubcomp{Version: Version311, PacketId: 1, Code: code.PacketIDNotFound}).Encode(buff))
+		assert.Equal(t, []byte{0x70, 0x02, 0x00, 0x01}, buff.Bytes())
+	})
+}
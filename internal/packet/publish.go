@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -38,7 +39,9 @@ type (
 		Retain      bool   //是否保留消息
 		TopicName   []byte //主题名
 		PacketId    Id     //报文标识符
-		Payload     []byte
+		// Properties is the publish properties, only available in v5.
+		Properties *Properties
+		Payload    []byte
 	}
 )
 
@@ -79,6 +82,11 @@ func (p *Publish) Encode(w io.Writer) (err error) {
 	if p.QoS == QoS1 || p.QoS == QoS2 {
 		writeUint16(buf, p.PacketId)
 	}
+	if IsVersion5(p.Version) {
+		if err = p.Properties.Pack(buf); err != nil {
+			return err
+		}
+	}
 	buf.Write(p.Payload)
 
 	// 写入
@@ -96,7 +104,7 @@ func (p *Publish) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	if !ValidTopicName(true, p.TopicName) {
+	if len(p.TopicName) != 0 && !ValidTopicName(true, p.TopicName) {
 		return xerror.ErrMalformed
 	}
 
@@ -107,11 +115,33 @@ func (p *Publish) Decode(r io.Reader) (err error) {
 			return
 		}
 	}
+	if IsVersion5(p.Version) {
+		if p.Properties, err = unpackProperties(buf, PUBLISH); err != nil {
+			return err
+		}
+		// A Topic Alias of 0 is not permitted. [MQTT-3.3.2-8]
+		if alias := p.Properties.TopicAlias; alias != nil && *alias == 0 {
+			return xerror.NewError(code.TopicAliasInvalid)
+		}
+	}
+	if len(p.TopicName) == 0 {
+		if !IsVersion5(p.Version) {
+			return xerror.ErrMalformed
+		}
+		// It is a Protocol Error if the Topic Name is zero length and there is no Topic Alias.
+		if p.Properties.TopicAlias == nil {
+			return xerror.ErrProtocol
+		}
+	}
 	p.Payload = buf.Next(buf.Len())
 	return nil
 }
 
 func (p *Publish) String() string {
+	if IsVersion5(p.Version) {
+		return fmt.Sprintf("Publish - Version: %v, PacketId: %v, Dup: %v, Qos: %v, Retain: %v, TopicName: %s, Properties: %s, Payload: %s",
+			p.Version, p.PacketId, p.Dup, p.QoS, p.Retain, p.TopicName, p.Properties, p.Payload)
+	}
 	return fmt.Sprintf("Publish - Version: %v, PacketId: %v, Dup: %v, Qos: %v, Retain: %v, TopicName: %s, Payload: %s",
 		p.Version, p.PacketId, p.Dup, p.QoS, p.Retain, p.TopicName, p.Payload)
 }
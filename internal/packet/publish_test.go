@@ -19,6 +19,8 @@ package packet
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 	"reflect"
 	"testing"
@@ -87,3 +89,64 @@ func TestReadWritePublishPacket_V311(t *testing.T) {
 	}
 
 }
+
+func TestReadWritePublishPacket_V5(t *testing.T) {
+	a := assert.New(t)
+	expiry := uint32(10)
+	pub := &Publish{
+		Version:   Version5,
+		QoS:       QoS1,
+		TopicName: []byte("a/b"),
+		PacketId:  1,
+		Properties: &Properties{
+			MessageExpiry:          &expiry,
+			ContentType:            []byte("json"),
+			SubscriptionIdentifier: []uint32{1, 268435455},
+			User:                   []UserProperty{{K: []byte("k"), V: []byte("v")}},
+		},
+		Payload: []byte("payload"),
+	}
+	buf := &bytes.Buffer{}
+	a.NoError(NewWriter(buf).WritePacketAndFlush(pub))
+	reader := NewReader(buf)
+	reader.version = Version5
+	packet, err := reader.Read()
+	a.NoError(err)
+	p, ok := packet.(*Publish)
+	if a.True(ok) {
+		a.Equal(pub.TopicName, p.TopicName)
+		a.Equal(pub.Payload, p.Payload)
+		a.Equal(pub.Properties, p.Properties)
+	}
+}
+
+func TestReadPublishPacket_TopicAlias(t *testing.T) {
+	a := assert.New(t)
+	read := func(pub *Publish) (Packet, error) {
+		buf := &bytes.Buffer{}
+		a.NoError(NewWriter(buf).WritePacketAndFlush(pub))
+		reader := NewReader(buf)
+		reader.version = pub.Version
+		return reader.Read()
+	}
+	alias := uint16(1)
+	p, err := read(&Publish{Version: Version5, Properties: &Properties{TopicAlias: &alias}, Payload: []byte("payload")})
+	a.NoError(err)
+	if pub, ok := p.(*Publish); a.True(ok) {
+		a.Empty(pub.TopicName)
+		a.Equal(alias, *pub.Properties.TopicAlias)
+		a.Equal([]byte("payload"), pub.Payload)
+	}
+
+	_, err = read(&Publish{Version: Version5, Payload: []byte("payload")})
+	a.ErrorIs(err, xerror.ErrProtocol)
+
+	zero := uint16(0)
+	_, err = read(&Publish{Version: Version5, TopicName: []byte("a"), Properties: &Properties{TopicAlias: &zero}})
+	if a.Error(err) {
+		a.Equal(code.TopicAliasInvalid, err.(*xerror.Error).Code)
+	}
+
+	_, err = read(&Publish{Version: Version311, Payload: []byte("payload")})
+	a.ErrorIs(err, xerror.ErrMalformed)
+}
@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -32,6 +33,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the reason code, only available in v5.
+		Code code.Code
+		// Properties is the properties, only available in v5.
+		Properties *Properties
 	}
 )
 
@@ -51,6 +56,9 @@ func (p *Pubrec) Encode(w io.Writer) (err error) {
 	p.FixedHeader = pubrecDefaultFixedHeader
 	buf := &bytes.Buffer{}
 	writeUint16(buf, p.PacketId)
+	if err = writeAckReason(buf, p.Version, p.Code, p.Properties); err != nil {
+		return err
+	}
 	return encode(p.FixedHeader, buf, w)
 }
 
@@ -65,6 +73,7 @@ func (p *Pubrec) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	p.Code, p.Properties, err = readAckReason(buf, p.Version, PUBREC)
 	return
 
 }
@@ -76,6 +85,7 @@ func (p *Pubrec) String() string {
 // CreateNewPubrel returns the Pubrel struct related to the Pubrec struct in QoS 2.
 func (p *Pubrec) CreateNewPubrel() *Pubrel {
 	pub := &Pubrel{
+		Version:     p.Version,
 		FixedHeader: &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagPubrel, RemainLength: 2},
 		PacketId:    p.PacketId,
 	}
@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -28,6 +29,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the reason code, only available in v5.
+		Code code.Code
+		// Properties is the properties, only available in v5.
+		Properties *Properties
 	}
 )
 
@@ -37,6 +42,10 @@ func NewPubrel(fixedHeader *FixedHeader, version Version, r io.Reader) (*Pubrel,
 		FixedHeader: fixedHeader,
 		Version:     version,
 	}
+	// [MQTT-3.6.1-1]
+	if fixedHeader.Flags != FixedHeaderFlagPubrel {
+		return nil, xerror.ErrMalformed
+	}
 	err := p.Decode(r)
 	if err != nil {
 		return nil, err
@@ -44,9 +53,12 @@ func NewPubrel(fixedHeader *FixedHeader, version Version, r io.Reader) (*Pubrel,
 	return p, nil
 }
 func (p *Pubrel) Encode(w io.Writer) (err error) {
-	p.FixedHeader = &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagReserved}
+	p.FixedHeader = &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagPubrel}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, p.PacketId)
+	if err = writeAckReason(buf, p.Version, p.Code, p.Properties); err != nil {
+		return err
+	}
 	return encode(p.FixedHeader, buf, w)
 }
 
@@ -58,6 +70,10 @@ func (p *Pubrel) Decode(r io.Reader) (err error) {
 	}
 	buf := bytes.NewBuffer(b)
 	p.PacketId, err = readUint16(buf)
+	if err != nil {
+		return
+	}
+	p.Code, p.Properties, err = readAckReason(buf, p.Version, PUBREL)
 	return
 }
 
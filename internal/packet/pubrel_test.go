@@ -0,0 +1,44 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func TestNewPubrel(t *testing.T) {
+	t.Run("correct test", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		assert.NoError(t, (&Pubrel{Version: Version311, PacketId: 1}).Encode(buff))
+		assert.Equal(t, []byte{0x62, 0x02, 0x00, 0x01}, buff.Bytes())
+
+		fixedHeader := &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagPubrel, RemainLength: 2}
+		pubrel, err := NewPubrel(fixedHeader, Version311, bytes.NewBuffer(buff.Bytes()[2:]))
+		assert.NoError(t, err)
+		assert.Equal(t, Id(1), pubrel.PacketId)
+	})
+
+	t.Run("flags error", func(t *testing.T) {
+		fixedHeader := &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagReserved, RemainLength: 2}
+		pubrel, err := NewPubrel(fixedHeader, Version311, bytes.NewBuffer([]byte{0x0, 0x1}))
+		assert.ErrorIs(t, err, xerror.ErrMalformed)
+		assert.Nil(t, pubrel)
+	})
+}
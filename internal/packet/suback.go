@@ -31,7 +31,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
-		Payload     []code.Code
+		// Properties is the suback properties, only available in v5.
+		Properties *Properties
+		// Payload contains the granted QoS or the reason code of each topic filter in SUBSCRIBE.
+		Payload []code.Code
 	}
 )
 
@@ -49,6 +52,11 @@ func (s *Suback) Encode(w io.Writer) (err error) {
 	s.FixedHeader = subackDefaultFixedHeader
 	bufw := &bytes.Buffer{}
 	writeUint16(bufw, s.PacketId)
+	if IsVersion5(s.Version) {
+		if err = s.Properties.Pack(bufw); err != nil {
+			return err
+		}
+	}
 
 	bufw.Write(s.Payload)
 	return encode(s.FixedHeader, bufw, w)
@@ -66,6 +74,11 @@ func (s *Suback) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return xerror.ErrMalformed
 	}
+	if IsVersion5(s.Version) {
+		if s.Properties, err = unpackProperties(buf, SUBACK); err != nil {
+			return err
+		}
+	}
 
 	for buf.Len() != 0 {
 		b, err := buf.ReadByte()
@@ -78,5 +91,5 @@ func (s *Suback) Decode(r io.Reader) (err error) {
 }
 
 func (s *Suback) String() string {
-	return fmt.Sprintf("Suback - Versoin: %s, PacketId: %d", s.Version, s.PacketId)
+	return fmt.Sprintf("Suback - Versoin: %s, PacketId: %d, Payload: %v", s.Version, s.PacketId, s.Payload)
 }
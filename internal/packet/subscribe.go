@@ -28,7 +28,9 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
-		Topics      []*Topic //suback响应之前填充
+		// Properties is the subscribe properties, only available in v5.
+		Properties *Properties
+		Topics     []*Topic //suback响应之前填充
 	}
 )
 
@@ -49,11 +51,26 @@ func (s *Subscribe) Encode(w io.Writer) (err error) {
 	s.FixedHeader = &FixedHeader{PacketType: SUBSCRIBE, Flags: FixedHeaderFlagSubscribe}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, s.PacketId)
+	if IsVersion5(s.Version) {
+		if err = s.Properties.Pack(buf); err != nil {
+			return err
+		}
+	}
 
 	// payload
 	for _, t := range s.Topics {
 		writeBinary(buf, []byte(t.Name))
-		buf.WriteByte(t.QoS)
+		var opts = t.QoS
+		if IsVersion5(s.Version) {
+			if t.NoLocal {
+				opts |= 1 << 2
+			}
+			if t.RetainAsPublished {
+				opts |= 1 << 3
+			}
+			opts |= t.RetainHandling << 4
+		}
+		buf.WriteByte(opts)
 	}
 	return encode(s.FixedHeader, buf, w)
 }
@@ -70,6 +87,15 @@ func (s *Subscribe) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return err
 	}
+	if IsVersion5(s.Version) {
+		if s.Properties, err = unpackProperties(bufr, SUBSCRIBE); err != nil {
+			return err
+		}
+		// The Subscription Identifier can only be included once in SUBSCRIBE.
+		if len(s.Properties.SubscriptionIdentifier) > 1 {
+			return xerror.ErrProtocol
+		}
+	}
 	// topics
 	for bufr.Len() != 0 {
 		topicFilter, err := UTF8DecodedStrings(true, bufr)
@@ -86,16 +112,34 @@ func (s *Subscribe) Decode(r io.Reader) (err error) {
 		topic := &Topic{
 			Name: string(topicFilter),
 		}
-		topic.QoS = topicOpts
+		if IsVersion5(s.Version) {
+			topic.QoS = topicOpts & 0x03
+			topic.NoLocal = (1 & (topicOpts >> 2)) > 0
+			topic.RetainAsPublished = (1 & (topicOpts >> 3)) > 0
+			topic.RetainHandling = 3 & (topicOpts >> 4)
+			// Bits 6 and 7 of the Subscription Options byte are reserved [MQTT-3.8.3-5]
+			if topicOpts>>6 != 0 || topic.RetainHandling > RetainHandlingNotSend {
+				return xerror.ErrProtocol
+			}
+		} else {
+			topic.QoS = topicOpts
+		}
 		if topic.QoS > QoS2 {
 			return xerror.ErrProtocol
 		}
 		s.Topics = append(s.Topics, topic)
 
 	}
+	// The payload of a SUBSCRIBE packet MUST contain at least one Topic Filter. [MQTT-3.8.3-2]
+	if len(s.Topics) == 0 {
+		return xerror.ErrProtocol
+	}
 	return
 }
 
 func (s *Subscribe) String() string {
+	if IsVersion5(s.Version) {
+		return fmt.Sprintf("Subscribe - Versioin: %s,PacketId: %d, Properties: %s, Topics: %v", s.Version, s.PacketId, s.Properties, s.Topics)
+	}
 	return fmt.Sprintf("Subscribe - Versioin: %s,PacketId: %d, Topics: %v", s.Version, s.PacketId, s.Topics)
 }
@@ -0,0 +1,56 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func TestSubscribe_V5SubscriptionIdentifier(t *testing.T) {
+	subscribe := &Subscribe{
+		Version:    Version5,
+		PacketId:   1,
+		Properties: &Properties{SubscriptionIdentifier: []uint32{268435455}},
+		Topics:     []*Topic{{SubOptions: SubOptions{QoS: QoS1, NoLocal: true}, Name: "a/b"}},
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, subscribe.Encode(buf))
+	reader := NewReader(buf)
+	reader.version = Version5
+	p, err := reader.Read()
+	if assert.NoError(t, err) {
+		got := p.(*Subscribe)
+		assert.Equal(t, subscribe.Properties.SubscriptionIdentifier, got.Properties.SubscriptionIdentifier)
+		assert.Equal(t, subscribe.Topics, got.Topics)
+	}
+
+	decode := func(props ...byte) error {
+		b := append([]byte{0x00, 0x01, byte(len(props))}, props...)
+		b = append(b, 0x00, 0x01, 'a', 0x01)
+		_, err := NewSubscribe(&FixedHeader{PacketType: SUBSCRIBE, Flags: FixedHeaderFlagSubscribe, RemainLength: len(b)}, Version5, bytes.NewBuffer(b))
+		return err
+	}
+	assert.NoError(t, decode(PropUser, 0x00, 0x01, 'k', 0x00, 0x01, 'v'))
+	// It is a Protocol Error if the Subscription Identifier has a value of 0.
+	assert.ErrorIs(t, decode(PropSubscriptionIdentifier, 0x00), xerror.ErrProtocol)
+	// The Subscription Identifier can only be included once.
+	assert.ErrorIs(t, decode(PropSubscriptionIdentifier, 0x01, PropSubscriptionIdentifier, 0x02), xerror.ErrProtocol)
+	assert.ErrorIs(t, decode(PropSessionExpiryInterval, 0x00, 0x00, 0x00, 0x01), xerror.ErrProtocol)
+}
@@ -24,6 +24,16 @@ import (
 
 const TopicMaxLen = 65535
 
+// Retain Handling options of the subscription.
+const (
+	// RetainHandlingSend sends retained messages at the time of the subscribe.
+	RetainHandlingSend byte = 0
+	// RetainHandlingSendIfNotExist sends retained messages at subscribe only if the subscription does not currently exist.
+	RetainHandlingSendIfNotExist byte = 1
+	// RetainHandlingNotSend does not send retained messages at the time of the subscribe.
+	RetainHandlingNotSend byte = 2
+)
+
 type (
 	// Topic represents the MQTT Topic
 	Topic struct {
@@ -29,17 +29,37 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
-		Payload     []code.Code
+		// Properties is the unsuback properties, only available in v5.
+		Properties *Properties
+		// Payload contains the reason code of each topic filter in UNSUBSCRIBE, only available in v5.
+		Payload []code.Code
 	}
 )
 
+// NewUnsuback returns a Unsuback instance by the given FixHeader and io.Reader.
+func NewUnsuback(fixedHeader *FixedHeader, version Version, r io.Reader) (*Unsuback, error) {
+	p := &Unsuback{FixedHeader: fixedHeader, Version: version}
+	if fixedHeader.Flags != FixedHeaderFlagReserved {
+		return nil, xerror.ErrMalformed
+	}
+	err := p.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 func (u *Unsuback) Encode(w io.Writer) (err error) {
 	u.FixedHeader = &FixedHeader{PacketType: UNSUBACK, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, u.PacketId)
-
-	// payload
-	buf.Write(u.Payload)
+	if IsVersion5(u.Version) {
+		if err = u.Properties.Pack(buf); err != nil {
+			return err
+		}
+		// payload
+		buf.Write(u.Payload)
+	}
 
 	return encode(u.FixedHeader, buf, w)
 }
@@ -55,9 +75,17 @@ func (u *Unsuback) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	if IsVersion5(u.Version) {
+		if u.Properties, err = unpackProperties(buf, UNSUBACK); err != nil {
+			return err
+		}
+		u.Payload = buf.Next(buf.Len())
+	} else if buf.Len() != 0 {
+		return xerror.ErrMalformed
+	}
 	return nil
 }
 
 func (u *Unsuback) String() string {
-	return fmt.Sprintf("Unsuback - Version: %s, PacketId: %d", u.Version, u.PacketId)
+	return fmt.Sprintf("Unsuback - Version: %s, PacketId: %d, Payload: %v", u.Version, u.PacketId, u.Payload)
 }
@@ -0,0 +1,49 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"testing"
+)
+
+func TestUnsuback(t *testing.T) {
+	t.Run("v5", func(t *testing.T) {
+		unsuback := &Unsuback{Version: Version5, PacketId: 1, Payload: []code.Code{code.Success, code.NoSubscriptionExisted}}
+		buff := &bytes.Buffer{}
+		assert.NoError(t, unsuback.Encode(buff))
+		assert.Equal(t, []byte{0xb0, 0x05, 0x00, 0x01, 0x00, code.Success, code.NoSubscriptionExisted}, buff.Bytes())
+
+		reader := NewReader(buff)
+		reader.version = Version5
+		p, err := reader.Read()
+		assert.NoError(t, err)
+		if got, ok := p.(*Unsuback); assert.True(t, ok) {
+			assert.Equal(t, unsuback.Payload, got.Payload)
+		}
+	})
+	t.Run("v311", func(t *testing.T) {
+		buff := &bytes.Buffer{}
+		assert.NoError(t, (&Unsuback{Version: Version311, PacketId: 1, Payload: []code.Code{code.Success}}).Encode(buff))
+		assert.Equal(t, []byte{0xb0, 0x02, 0x00, 0x01}, buff.Bytes())
+		p, err := NewReader(buff).Read()
+		assert.NoError(t, err)
+		assert.IsType(t, &Unsuback{}, p)
+	})
+}
@@ -28,7 +28,9 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
-		Topics      []string
+		// Properties is the unsubscribe properties, only available in v5.
+		Properties *Properties
+		Topics     []string
 	}
 )
 
@@ -50,6 +52,11 @@ func (u *Unsubscribe) Encode(w io.Writer) (err error) {
 	u.FixedHeader = &FixedHeader{PacketType: UNSUBSCRIBE, Flags: FixedHeaderFlagUnsubscribe}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, u.PacketId)
+	if IsVersion5(u.Version) {
+		if err = u.Properties.Pack(buf); err != nil {
+			return err
+		}
+	}
 	for _, topic := range u.Topics {
 		writeBinary(buf, []byte(topic))
 	}
@@ -67,6 +74,11 @@ func (u *Unsubscribe) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	if IsVersion5(u.Version) {
+		if u.Properties, err = unpackProperties(bufr, UNSUBSCRIBE); err != nil {
+			return err
+		}
+	}
 	// topics
 	for bufr.Len() != 0 {
 		topicFilter, err := UTF8DecodedStrings(true, bufr)
@@ -78,6 +90,10 @@ func (u *Unsubscribe) Decode(r io.Reader) (err error) {
 		}
 		u.Topics = append(u.Topics, string(topicFilter))
 	}
+	// The Payload of an UNSUBSCRIBE packet MUST contain at least one Topic Filter. [MQTT-3.10.3-2]
+	if len(u.Topics) == 0 {
+		return xerror.ErrProtocol
+	}
 	return
 }
 
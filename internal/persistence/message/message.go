@@ -36,6 +36,7 @@ type (
 		PayloadFormat          packet.PayloadFormat
 		ResponseTopic          string
 		SubscriptionIdentifier []uint32
+		UserProperties         []packet.UserProperty
 	}
 )
 
@@ -45,14 +46,68 @@ func (m *Message) String() string {
 	)
 }
 
+// Copy makes a deep copy of the message.
+func (m *Message) Copy() *Message {
+	c := *m
+	if m.Payload != nil {
+		c.Payload = make([]byte, len(m.Payload))
+		copy(c.Payload, m.Payload)
+	}
+	if m.CorrelationData != nil {
+		c.CorrelationData = make([]byte, len(m.CorrelationData))
+		copy(c.CorrelationData, m.CorrelationData)
+	}
+	if m.SubscriptionIdentifier != nil {
+		c.SubscriptionIdentifier = make([]uint32, len(m.SubscriptionIdentifier))
+		copy(c.SubscriptionIdentifier, m.SubscriptionIdentifier)
+	}
+	if m.UserProperties != nil {
+		c.UserProperties = make([]packet.UserProperty, len(m.UserProperties))
+		for i, u := range m.UserProperties {
+			c.UserProperties[i] = packet.UserProperty{K: copyBytes(u.K), V: copyBytes(u.V)}
+		}
+	}
+	return &c
+}
+
+// stringBytes returns nil if the string is empty, so that the absent property is not encoded.
+func stringBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
 func FromPublish(publish *packet.Publish) *Message {
-	return &Message{
+	m := &Message{
 		Dup:      publish.Dup,
 		QoS:      publish.QoS,
 		Retained: publish.Retain,
 		Topic:    string(publish.TopicName),
 		Payload:  publish.Payload,
 	}
+	if p := publish.Properties; packet.IsVersion5(publish.Version) && p != nil {
+		if p.PayloadFormat != nil {
+			m.PayloadFormat = *p.PayloadFormat
+		}
+		if p.MessageExpiry != nil {
+			m.MessageExpiry = *p.MessageExpiry
+		}
+		m.ContentType = string(p.ContentType)
+		m.CorrelationData = p.CorrelationData
+		m.ResponseTopic = string(p.ResponseTopic)
+		m.UserProperties = p.User
+	}
+	return m
 }
 
 // TotalBytes return the publish packets total bytes.
@@ -84,6 +139,9 @@ func (m *Message) TotalBytes(version packet.Version) uint32 {
 		if l := len(m.ResponseTopic); l != 0 {
 			propertyLenght += 3 + l
 		}
+		for _, v := range m.UserProperties {
+			propertyLenght += 5 + len(v.K) + len(v.V)
+		}
 
 		remainLenght += propertyLenght + getVariableLength(propertyLenght)
 	}
@@ -120,6 +178,22 @@ func ToPublish(msg *Message, version packet.Version) *packet.Publish {
 		Payload:   msg.Payload,
 		Version:   version,
 	}
-
+	if packet.IsVersion5(version) {
+		pub.Properties = &packet.Properties{
+			ContentType:            stringBytes(msg.ContentType),
+			ResponseTopic:          stringBytes(msg.ResponseTopic),
+			SubscriptionIdentifier: msg.SubscriptionIdentifier,
+			User:                   msg.UserProperties,
+		}
+		if len(msg.CorrelationData) != 0 {
+			pub.Properties.CorrelationData = msg.CorrelationData
+		}
+		if msg.PayloadFormat == packet.PayloadFormatString {
+			pub.Properties.PayloadFormat = &msg.PayloadFormat
+		}
+		if msg.MessageExpiry != 0 {
+			pub.Properties.MessageExpiry = &msg.MessageExpiry
+		}
+	}
 	return pub
 }
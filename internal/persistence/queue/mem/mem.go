@@ -3,7 +3,9 @@ package mem
 import (
 	"container/list"
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/queue"
 	"sync"
 	"time"
@@ -13,6 +15,22 @@ import (
 
 var _ queue.Queue = (*Queue)(nil)
 
+func init() {
+	persistence.RegisterQueueStore(persistence.Memory, NewStore())
+}
+
+// NewStore returns a queue.NewStore which creates memory queues.
+func NewStore() queue.NewStore {
+	return func(config *config.StoreType, opts *queue.StoreOptions) (queue.Queue, error) {
+		return New(Options{
+			MaxQueuedMsg:    opts.MaxQueuedMsg,
+			InflightExpiry:  opts.InflightExpiry,
+			ClientID:        opts.ClientId,
+			DefaultNotifier: opts.DefaultNotifier,
+		})
+	}
+}
+
 type Options struct {
 	MaxQueuedMsg    int
 	InflightExpiry  time.Duration
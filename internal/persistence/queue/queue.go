@@ -2,11 +2,27 @@ package queue
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
 	"io"
 	"time"
 )
 
+// NewStore creates the Queue of a client.
+type NewStore func(config *config.StoreType, opts *StoreOptions) (Queue, error)
+
+// StoreOptions is used to pass the client information and the queue settings to NewStore.
+type StoreOptions struct {
+	// ClientId is the client id of the queue owner.
+	ClientId string
+	// MaxQueuedMsg is the maximum queue length.
+	MaxQueuedMsg int
+	// InflightExpiry is the lifetime of the inflight message.
+	InflightExpiry time.Duration
+	// DefaultNotifier is used before Init is called.
+	DefaultNotifier Notifier
+}
+
 // InitOptions is used to pass some required client information to the queue.Init()
 type InitOptions struct {
 	// CleanStart is the cleanStart field in the connect packet.
@@ -3,8 +3,10 @@ package redis
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/queue"
 	red "github.com/yunqi/lighthouse/internal/redis"
 	"github.com/yunqi/lighthouse/internal/xerror"
@@ -20,6 +22,23 @@ const (
 
 var _ queue.Queue = (*Queue)(nil)
 
+func init() {
+	persistence.RegisterQueueStore(persistence.Redis, NewStore())
+}
+
+// NewStore returns a queue.NewStore which creates redis queues.
+func NewStore() queue.NewStore {
+	return func(config *config.StoreType, opts *queue.StoreOptions) (queue.Queue, error) {
+		return New(Options{
+			MaxQueuedMsg:    opts.MaxQueuedMsg,
+			ClientID:        opts.ClientId,
+			InflightExpiry:  opts.InflightExpiry,
+			DefaultNotifier: opts.DefaultNotifier,
+			Redis:           red.New(config.Redis.Addr, red.StoreOptions(&config.Redis)...),
+		})
+	}
+}
+
 func getKey(clientID string) string {
 	return queuePrefix + clientID
 }
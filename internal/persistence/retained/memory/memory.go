@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/retained"
+	"sync"
+	"time"
+)
+
+func init() {
+	persistence.RegisterRetainedStore(persistence.Memory, newStore())
+}
+
+var _ retained.Store = (*TrieDB)(nil)
+
+// TrieDB implement the retained.Store, it use trie tree to store retained messages.
+type TrieDB struct {
+	sync.RWMutex
+	userTrie *topicTrie
+}
+
+// newStore create a new TrieDB instance
+func newStore() retained.NewStore {
+	return func(config *config.StoreType) (retained.Store, error) {
+		return New(), nil
+	}
+}
+
+func New() *TrieDB {
+	return &TrieDB{
+		userTrie: newTopicTrie(),
+	}
+}
+
+func (db *TrieDB) GetRetainedMessage(ctx context.Context, topicName string) (*message.Message, error) {
+	msg, _ := db.GetRetainedMessageAndExpired(ctx, topicName)
+	return msg, nil
+}
+
+// GetRetainedMessageAndExpired is like GetRetainedMessage,
+// it also returns whether the retained message of the topic has expired and been removed.
+func (db *TrieDB) GetRetainedMessageAndExpired(ctx context.Context, topicName string) (msg *message.Message, expired bool) {
+	db.Lock()
+	defer db.Unlock()
+	now := time.Now()
+	node := db.userTrie.find(topicName)
+	if node == nil || node.msg == nil {
+		return nil, false
+	}
+	if node.expired(now) {
+		db.userTrie.remove(topicName)
+		return nil, true
+	}
+	return node.message(now), false
+}
+
+func (db *TrieDB) ClearAll(ctx context.Context) error {
+	db.Lock()
+	defer db.Unlock()
+	db.userTrie = newTopicTrie()
+	return nil
+}
+
+// AddOrReplace adds or replaces the retained message, which expires after its Message Expiry Interval.
+func (db *TrieDB) AddOrReplace(ctx context.Context, message *message.Message) error {
+	db.Lock()
+	defer db.Unlock()
+	var expiry time.Time
+	if message.MessageExpiry != 0 {
+		expiry = time.Now().Add(time.Duration(message.MessageExpiry) * time.Second)
+	}
+	db.userTrie.addRetainMsg(message.Topic, message, expiry)
+	return nil
+}
+
+func (db *TrieDB) Remove(ctx context.Context, topicName string) error {
+	db.Lock()
+	defer db.Unlock()
+	db.userTrie.remove(topicName)
+	return nil
+}
+
+func (db *TrieDB) GetMatchedMessages(ctx context.Context, topicFilter string) ([]*message.Message, error) {
+	msgs, _ := db.GetMatchedMessagesAndExpired(ctx, topicFilter)
+	return msgs, nil
+}
+
+// GetMatchedMessagesAndExpired is like GetMatchedMessages,
+// it also returns the topic names of the matched messages which have expired and been removed.
+func (db *TrieDB) GetMatchedMessagesAndExpired(ctx context.Context, topicFilter string) (msgs []*message.Message, expired []string) {
+	db.Lock()
+	defer db.Unlock()
+	return db.userTrie.getMatchedMessages(topicFilter, time.Now())
+}
+
+// Iterate iterates the retained messages which have not expired.
+func (db *TrieDB) Iterate(ctx context.Context, fn retained.IterateFn) error {
+	db.RLock()
+	defer db.RUnlock()
+	now := time.Now()
+	db.userTrie.preOrderTraverse(func(node *topicNode) bool {
+		if node.expired(now) {
+			return true
+		}
+		return fn(node.message(now))
+	})
+	return nil
+}
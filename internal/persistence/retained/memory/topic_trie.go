@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"strings"
+	"time"
+)
+
+// topicTrie
+type topicTrie = topicNode
+
+// children
+type children = map[string]*topicNode
+
+// topicNode
+type topicNode struct {
+	children  children
+	msg       *message.Message
+	expiry    time.Time  // the zero time means the message never expires
+	parent    *topicNode // pointer of parent node
+	topicName string
+}
+
+// newTopicTrie create a new trie tree
+func newTopicTrie() *topicTrie {
+	return newNode()
+}
+
+// newNode create a new trie node
+func newNode() *topicNode {
+	return &topicNode{
+		children: children{},
+	}
+}
+
+// newChild create a child node of t
+func (t *topicNode) newChild() *topicNode {
+	n := newNode()
+	n.parent = t
+	return n
+}
+
+// find walk through the tire and return the node that represent the topicName.
+// Return nil if not found
+func (t *topicTrie) find(topicName string) *topicNode {
+	topicSlice := strings.Split(topicName, "/")
+	var pNode = t
+	for _, lv := range topicSlice {
+		if _, ok := pNode.children[lv]; ok {
+			pNode = pNode.children[lv]
+		} else {
+			return nil
+		}
+	}
+	if pNode.msg != nil {
+		return pNode
+	}
+	return nil
+}
+
+// expired returns whether the retained message of the node has expired.
+func (t *topicNode) expired(now time.Time) bool {
+	return !t.expiry.IsZero() && !now.Before(t.expiry)
+}
+
+// message returns a copy of the retained message of the node,
+// the Message Expiry Interval is set to the remaining lifetime of the message. [MQTT-3.3.2-6]
+func (t *topicNode) message(now time.Time) *message.Message {
+	msg := t.msg.Copy()
+	if !t.expiry.IsZero() {
+		msg.MessageExpiry = uint32((t.expiry.Sub(now) + time.Second - 1) / time.Second)
+	}
+	return msg
+}
+
+// matchTopic walk through the tire and call the fn callback for each node with message witch match the topic filter.
+func (t *topicTrie) matchTopic(topicSlice []string, fn func(node *topicNode) bool) bool {
+	endFlag := len(topicSlice) == 1
+	// The Server MUST NOT match Topic Filters starting with a wildcard character (# or +) with Topic Names beginning with a $ character [MQTT-4.7.2-1]
+	isRoot := t.parent == nil
+	switch topicSlice[0] {
+	case "#":
+		if isRoot {
+			for name, c := range t.children {
+				if isSystemTopic(name) {
+					continue
+				}
+				if !c.preOrderTraverse(fn) {
+					return false
+				}
+			}
+			return true
+		}
+		return t.preOrderTraverse(fn)
+	case "+":
+		for name, c := range t.children {
+			if isRoot && isSystemTopic(name) {
+				continue
+			}
+			if endFlag {
+				if c.msg != nil && !fn(c) {
+					return false
+				}
+			} else if !c.matchTopic(topicSlice[1:], fn) {
+				return false
+			}
+		}
+	default:
+		if c := t.children[topicSlice[0]]; c != nil {
+			if endFlag {
+				if c.msg != nil {
+					return fn(c)
+				}
+				return true
+			}
+			return c.matchTopic(topicSlice[1:], fn)
+		}
+	}
+	return true
+}
+
+// getMatchedMessages returns all messages which match the topic filter,
+// the expired messages are removed and their topic names are returned.
+func (t *topicTrie) getMatchedMessages(topicFilter string, now time.Time) (rs []*message.Message, expired []string) {
+	topicSlice := strings.Split(topicFilter, "/")
+	t.matchTopic(topicSlice, func(node *topicNode) bool {
+		if node.expired(now) {
+			expired = append(expired, node.topicName)
+		} else {
+			rs = append(rs, node.message(now))
+		}
+		return true
+	})
+	for _, topicName := range expired {
+		t.remove(topicName)
+	}
+	return rs, expired
+}
+
+// addRetainMsg add or replace the retained message of the topic.
+func (t *topicTrie) addRetainMsg(topicName string, message *message.Message, expiry time.Time) {
+	topicSlice := strings.Split(topicName, "/")
+	var pNode = t
+	for _, lv := range topicSlice {
+		if _, ok := pNode.children[lv]; !ok {
+			pNode.children[lv] = pNode.newChild()
+		}
+		pNode = pNode.children[lv]
+	}
+	pNode.msg = message
+	pNode.expiry = expiry
+	pNode.topicName = topicName
+}
+
+// remove removes the retained message of the topic and prunes the empty nodes.
+func (t *topicTrie) remove(topicName string) {
+	topicSlice := strings.Split(topicName, "/")
+	l := len(topicSlice)
+	var pNode = t
+	for _, lv := range topicSlice {
+		if _, ok := pNode.children[lv]; ok {
+			pNode = pNode.children[lv]
+		} else {
+			return
+		}
+	}
+	pNode.msg = nil
+	pNode.expiry = time.Time{}
+	for i := l - 1; i >= 0 && pNode.parent != nil; i-- {
+		if pNode.msg != nil || len(pNode.children) != 0 {
+			return
+		}
+		delete(pNode.parent.children, topicSlice[i])
+		pNode = pNode.parent
+	}
+}
+
+func (t *topicTrie) preOrderTraverse(fn func(node *topicNode) bool) bool {
+	if t == nil {
+		return false
+	}
+	if t.msg != nil {
+		if !fn(t) {
+			return false
+		}
+	}
+	for _, c := range t.children {
+		if !c.preOrderTraverse(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSystemTopic(topicName string) bool {
+	return len(topicName) >= 1 && topicName[0] == '$'
+}
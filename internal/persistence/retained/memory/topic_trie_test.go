@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRetainedMatch = []struct {
+	topicFilter string
+	want        []string
+}{
+	{topicFilter: "#", want: []string{"a", "a/b", "a/b/c", "/a"}},
+	{topicFilter: "a/#", want: []string{"a", "a/b", "a/b/c"}},
+	{topicFilter: "a/+", want: []string{"a/b"}},
+	{topicFilter: "+/+", want: []string{"a/b", "/a"}},
+	{topicFilter: "a/b/c", want: []string{"a/b/c"}},
+	{topicFilter: "a/c", want: nil},
+	{topicFilter: "$SYS/#", want: []string{"$SYS/a"}},
+	{topicFilter: "+/a", want: []string{"/a"}},
+}
+
+func newRetainedTestDB() *TrieDB {
+	db := New()
+	for _, topic := range []string{"a", "a/b", "a/b/c", "/a", "$SYS/a"} {
+		_ = db.AddOrReplace(context.Background(), &message.Message{
+			Topic:    topic,
+			Payload:  []byte(topic),
+			Retained: true,
+		})
+	}
+	return db
+}
+
+func TestTrieDB_GetMatchedMessages(t *testing.T) {
+	a := assert.New(t)
+	db := newRetainedTestDB()
+	for _, v := range testRetainedMatch {
+		messages, err := db.GetMatchedMessages(context.Background(), v.topicFilter)
+		a.NoError(err)
+		var got []string
+		for _, msg := range messages {
+			a.Equal(msg.Topic, string(msg.Payload))
+			got = append(got, msg.Topic)
+		}
+		a.ElementsMatch(v.want, got, v.topicFilter)
+	}
+}
+
+func TestTrieDB_AddOrReplaceAndRemove(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	db := newRetainedTestDB()
+
+	err := db.AddOrReplace(ctx, &message.Message{Topic: "a/b", Payload: []byte("replaced")})
+	a.NoError(err)
+	msg, err := db.GetRetainedMessage(ctx, "a/b")
+	a.NoError(err)
+	a.Equal([]byte("replaced"), msg.Payload)
+
+	a.NoError(db.Remove(ctx, "a/b"))
+	msg, err = db.GetRetainedMessage(ctx, "a/b")
+	a.NoError(err)
+	a.Nil(msg)
+	// the children of the removed topic are kept.
+	msg, err = db.GetRetainedMessage(ctx, "a/b/c")
+	a.NoError(err)
+	a.Equal("a/b/c", msg.Topic)
+
+	var count int
+	a.NoError(db.Iterate(ctx, func(msg *message.Message) bool {
+		count++
+		return true
+	}))
+	a.Equal(4, count)
+
+	a.NoError(db.ClearAll(ctx))
+	messages, err := db.GetMatchedMessages(ctx, "#")
+	a.NoError(err)
+	a.Len(messages, 0)
+}
+
+func TestTopicTrie_expiry(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	trie := newTopicTrie()
+	trie.addRetainMsg("a", &message.Message{Topic: "a", MessageExpiry: 10}, now.Add(10*time.Second))
+	trie.addRetainMsg("a/b", &message.Message{Topic: "a/b", MessageExpiry: 10}, now.Add(-time.Second))
+	trie.addRetainMsg("a/c", &message.Message{Topic: "a/c"}, time.Time{})
+
+	messages, expired := trie.getMatchedMessages("#", now.Add(3*time.Second))
+	a.Len(messages, 2)
+	a.Equal([]string{"a/b"}, expired)
+	for _, msg := range messages {
+		switch msg.Topic {
+		case "a":
+			a.Equal(uint32(7), msg.MessageExpiry)
+		case "a/c":
+			a.Zero(msg.MessageExpiry)
+		default:
+			a.Fail("expired message", msg.Topic)
+		}
+	}
+	// the expired message is removed.
+	a.Nil(trie.find("a/b"))
+	// the stored message is not changed.
+	a.Equal(uint32(10), trie.find("a").msg.MessageExpiry)
+}
+
+func TestTrieDB_expiry(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	db := New()
+	for _, topic := range []string{"a", "b"} {
+		a.NoError(db.AddOrReplace(ctx, &message.Message{Topic: topic, Payload: []byte(topic), MessageExpiry: 10}))
+		db.userTrie.find(topic).expiry = time.Now().Add(-time.Second)
+	}
+
+	a.NoError(db.Iterate(ctx, func(msg *message.Message) bool {
+		a.Fail("expired message", msg.Topic)
+		return true
+	}))
+	msg, expired := db.GetRetainedMessageAndExpired(ctx, "a")
+	a.Nil(msg)
+	a.True(expired)
+	msg, expired = db.GetRetainedMessageAndExpired(ctx, "a")
+	a.Nil(msg)
+	a.False(expired)
+	messages, expiredTopics := db.GetMatchedMessagesAndExpired(ctx, "#")
+	a.Empty(messages)
+	a.Equal([]string{"b"}, expiredTopics)
+	a.Empty(db.userTrie.children)
+}
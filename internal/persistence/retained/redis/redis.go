@@ -0,0 +1,170 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/message/encoding"
+	"github.com/yunqi/lighthouse/internal/persistence/retained"
+	"github.com/yunqi/lighthouse/internal/persistence/retained/memory"
+	red "github.com/yunqi/lighthouse/internal/redis"
+	"sync"
+	"time"
+)
+
+const (
+	retainedKey = "lighthouse:retained"
+)
+
+var _ retained.Store = (*Store)(nil)
+
+func init() {
+	persistence.RegisterRetainedStore(persistence.Redis, New())
+}
+
+// Store persists the retained messages in a redis hash (topic name -> message)
+// and keeps a memory copy of them for matching.
+type Store struct {
+	mu       sync.Mutex
+	memStore *memory.TrieDB
+	r        *red.Redis
+}
+
+func New() retained.NewStore {
+	return func(config *config.StoreType) (retained.Store, error) {
+		s := &Store{
+			memStore: memory.New(),
+			r:        red.New(config.Redis.Addr, red.StoreOptions(&config.Redis)...),
+		}
+		return s, s.load(context.Background())
+	}
+}
+
+// encodeRetained encodes the expiry time and the retained message, the zero expiry means the message never expires.
+func encodeRetained(msg *message.Message, now time.Time) []byte {
+	var expiry int64
+	if msg.MessageExpiry != 0 {
+		expiry = now.Add(time.Duration(msg.MessageExpiry) * time.Second).Unix()
+	}
+	b := bytes.NewBuffer(make([]byte, 8))
+	binary.BigEndian.PutUint64(b.Bytes(), uint64(expiry))
+	encoding.EncodeMessage(msg, b)
+	return b.Bytes()
+}
+
+// decodeRetained decodes the retained message encoded by encodeRetained.
+// The Message Expiry Interval of the message is set to the remaining lifetime, it returns nil if the message has expired.
+func decodeRetained(b []byte, now time.Time) (*message.Message, error) {
+	if len(b) < 8 {
+		return nil, errors.New("invalid input length")
+	}
+	msg, err := encoding.DecodeMessageFromBytes(b[8:])
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	if expiry := int64(binary.BigEndian.Uint64(b[:8])); expiry != 0 {
+		remaining := time.Unix(expiry, 0).Sub(now)
+		if remaining <= 0 {
+			return nil, nil
+		}
+		msg.MessageExpiry = uint32((remaining + time.Second - 1) / time.Second)
+	}
+	return msg, nil
+}
+
+// load loads all retained messages from redis into memory, the expired messages are removed.
+func (s *Store) load(ctx context.Context) error {
+	rs, err := s.r.Hgetall(ctx, retainedKey)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for topicName, v := range rs {
+		msg, err := decodeRetained([]byte(v), now)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			if _, err = s.r.Hdel(ctx, retainedKey, topicName); err != nil {
+				return err
+			}
+			continue
+		}
+		_ = s.memStore.AddOrReplace(ctx, msg)
+	}
+	return nil
+}
+
+func (s *Store) GetRetainedMessage(ctx context.Context, topicName string) (*message.Message, error) {
+	msg, expired := s.memStore.GetRetainedMessageAndExpired(ctx, topicName)
+	if expired {
+		if err := s.removeExpired(ctx, topicName); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// removeExpired removes the expired messages from redis, otherwise they are kept until the next load.
+func (s *Store) removeExpired(ctx context.Context, topicNames ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, topicName := range topicNames {
+		// the topic may have been retained again since the message expired.
+		if msg, _ := s.memStore.GetRetainedMessage(ctx, topicName); msg != nil {
+			continue
+		}
+		if _, err := s.r.Hdel(ctx, retainedKey, topicName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) ClearAll(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.r.Del(ctx, retainedKey)
+	if err != nil {
+		return err
+	}
+	return s.memStore.ClearAll(ctx)
+}
+
+func (s *Store) AddOrReplace(ctx context.Context, message *message.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.r.Hset(ctx, retainedKey, message.Topic, encodeRetained(message, time.Now()))
+	if err != nil {
+		return err
+	}
+	return s.memStore.AddOrReplace(ctx, message)
+}
+
+func (s *Store) Remove(ctx context.Context, topicName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.r.Hdel(ctx, retainedKey, topicName)
+	if err != nil {
+		return err
+	}
+	return s.memStore.Remove(ctx, topicName)
+}
+
+func (s *Store) GetMatchedMessages(ctx context.Context, topicFilter string) ([]*message.Message, error) {
+	msgs, expired := s.memStore.GetMatchedMessagesAndExpired(ctx, topicFilter)
+	if len(expired) != 0 {
+		if err := s.removeExpired(ctx, expired...); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func (s *Store) Iterate(ctx context.Context, fn retained.IterateFn) error {
+	return s.memStore.Iterate(ctx, fn)
+}
@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeRetained(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+
+	msg := &message.Message{Topic: "a", Payload: []byte("a"), Retained: true}
+	got, err := decodeRetained(encodeRetained(msg, now), now.Add(time.Hour))
+	a.NoError(err)
+	a.Equal(msg, got)
+
+	msg = &message.Message{Topic: "a", Payload: []byte("a"), Retained: true, MessageExpiry: 10}
+	b := encodeRetained(msg, now)
+	got, err = decodeRetained(b, now.Add(3*time.Second))
+	a.NoError(err)
+	a.Equal("a", got.Topic)
+	a.Equal(uint32(7), got.MessageExpiry)
+
+	got, err = decodeRetained(b, now.Add(10*time.Second))
+	a.NoError(err)
+	a.Nil(got)
+
+	_, err = decodeRetained([]byte{0}, now)
+	a.Error(err)
+}
@@ -0,0 +1,33 @@
+package retained
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+)
+
+type (
+	// IterateFn is the callback function used by Iterate()
+	// Return false means to stop the iteration.
+	IterateFn func(message *message.Message) bool
+	NewStore  func(config *config.StoreType) (Store, error)
+)
+
+// Store is the interface used by server to handle the operations of retained messages.
+type Store interface {
+	// GetRetainedMessage returns the retained message of the given topic name.
+	// Return nil if the message not exists or has expired.
+	GetRetainedMessage(ctx context.Context, topicName string) (*message.Message, error)
+	// ClearAll clears all retained messages.
+	ClearAll(ctx context.Context) error
+	// AddOrReplace adds or replaces a retained message, which expires after its Message Expiry Interval.
+	AddOrReplace(ctx context.Context, message *message.Message) error
+	// Remove removes the retained message of the given topic name.
+	Remove(ctx context.Context, topicName string) error
+	// GetMatchedMessages returns all retained messages that match the given topic filter.
+	// The expired messages are removed, and the Message Expiry Interval of the returned messages is their remaining lifetime.
+	GetMatchedMessages(ctx context.Context, topicFilter string) ([]*message.Message, error)
+	// Iterate iterates all retained messages which have not expired. The callback is called once for each message.
+	// If callback return false, the iteration will be stopped.
+	Iterate(ctx context.Context, fn IterateFn) error
+}
@@ -31,15 +31,8 @@ type Store struct {
 
 func New() session.NewStore {
 	return func(config *config.StoreType) (session.Store, error) {
-		var opts []red.Option
-		switch config.Type {
-		case red.NodeType:
-			opts = append(opts, red.WithNodeType())
-		case red.ClusterType:
-			opts = append(opts, red.WithClusterType())
-		}
 		return &Store{
-			r: red.New(config.Redis.Addr, opts...),
+			r: red.New(config.Redis.Addr, red.StoreOptions(&config.Redis)...),
 		}, nil
 	}
 }
@@ -27,7 +27,7 @@ type TrieDB struct {
 	systemTrie  *topicTrie
 
 	// shared subscription which begin with "$share"
-	sharedIndex map[string]map[string]*topicNode // [clientID][shareName/topicFilter]
+	sharedIndex map[string]map[string]*topicNode // [clientID][$share/shareName/topicFilter]
 	sharedTrie  *topicTrie
 
 	// statistics of the server and each client
@@ -104,12 +104,11 @@ func iterateShared(fn subscription.IterateFn, options subscription.IterationOpti
 	}
 	// 查询指定clientID下的所有topic
 	if options.ClientID != "" {
-		for _, v := range index[options.ClientID] {
-			for _, c := range v.shared {
-				if sub, ok := c[options.ClientID]; ok {
-					if !fn(options.ClientID, sub) {
-						return false
-					}
+		for topicName, v := range index[options.ClientID] {
+			shareName, _ := subscription.SplitTopic(topicName)
+			if sub, ok := v.shared[shareName][options.ClientID]; ok {
+				if !fn(options.ClientID, sub) {
+					return false
 				}
 			}
 		}
@@ -298,6 +297,7 @@ func (db *TrieDB) SubscribeLocked(ctx context.Context, clientID string, subscrip
 		if sub.ShareName != "" {
 			node = db.sharedTrie.subscribe(clientID, sub)
 			index = db.sharedIndex
+			topicName = sub.GetFullTopicName()
 		} else if isSystemTopic(topicName) {
 			node = db.systemTrie.subscribe(clientID, sub)
 			index = db.systemIndex
@@ -335,12 +335,13 @@ func (db *TrieDB) Subscribe(ctx context.Context, clientID string, subscriptions
 func (db *TrieDB) UnsubscribeLocked(ctx context.Context, clientID string, topics ...string) {
 	var index map[string]map[string]*topicNode
 	var topicTrie *topicTrie
-	for _, topic := range topics {
-		var shareName string
-		shareName, topic := subscription.SplitTopic(topic)
+	for _, fullTopic := range topics {
+		shareName, topic := subscription.SplitTopic(fullTopic)
+		key := topic
 		if shareName != "" {
 			topicTrie = db.sharedTrie
 			index = db.sharedIndex
+			key = fullTopic
 		} else if isSystemTopic(topic) {
 			index = db.systemIndex
 			topicTrie = db.systemTrie
@@ -349,11 +350,11 @@ func (db *TrieDB) UnsubscribeLocked(ctx context.Context, clientID string, topics
 			topicTrie = db.userTrie
 		}
 		if _, ok := index[clientID]; ok {
-			if _, ok := index[clientID][topic]; ok {
+			if _, ok := index[clientID][key]; ok {
 				db.stats.SubscriptionsCurrent--
 				db.clientStats[clientID].SubscriptionsCurrent--
 			}
-			delete(index[clientID], topic)
+			delete(index[clientID], key)
 		}
 		topicTrie.unsubscribe(clientID, topic, shareName)
 	}
@@ -373,8 +374,17 @@ func (db *TrieDB) unsubscribeAll(index map[string]map[string]*topicNode, clientI
 		db.clientStats[clientID].SubscriptionsCurrent -= uint64(len(index[clientID]))
 	}
 	for topicName, node := range index[clientID] {
-		delete(node.clients, clientID)
-		if len(node.clients) == 0 && len(node.children) == 0 {
+		if shareName, _ := subscription.SplitTopic(topicName); shareName != "" {
+			if c := node.shared[shareName]; c != nil {
+				delete(c, clientID)
+				if len(c) == 0 {
+					delete(node.shared, shareName)
+				}
+			}
+		} else {
+			delete(node.clients, clientID)
+		}
+		if len(node.clients) == 0 && len(node.shared) == 0 && len(node.children) == 0 {
 			ss := strings.Split(topicName, "/")
 			delete(node.parent.children, ss[len(ss)-1])
 		}
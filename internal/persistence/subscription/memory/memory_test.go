@@ -0,0 +1,50 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"testing"
+)
+
+func TestTrieDB_SharedSubscription(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	_, err := db.Subscribe(ctx, "c",
+		&sub.Subscription{ShareName: "g1", TopicFilter: "a"},
+		&sub.Subscription{ShareName: "g2", TopicFilter: "a"},
+		&sub.Subscription{TopicFilter: "a"},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, subscription.GetClientSubscriptions(ctx, db, "c", subscription.TypeAll), 3)
+
+	assert.NoError(t, db.Unsubscribe(ctx, "c", "$share/g1/a"))
+	subs := subscription.GetClientSubscriptions(ctx, db, "c", subscription.TypeShared)
+	if assert.Len(t, subs, 1) {
+		assert.Equal(t, "g2", subs[0].ShareName)
+	}
+	assert.Len(t, subscription.GetTopicMatched(ctx, db, "a", subscription.TypeShared)["c"], 1)
+
+	assert.NoError(t, db.UnsubscribeAll(ctx, "c"))
+	assert.Empty(t, subscription.GetTopicMatched(ctx, db, "a", subscription.TypeAll))
+	stats, err := db.GetClientStats("c")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), stats.SubscriptionsCurrent)
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
 	red "github.com/yunqi/lighthouse/internal/redis"
 	subsc "github.com/yunqi/lighthouse/internal/subscription"
-	"strings"
 	"sync"
 )
 
@@ -77,7 +76,7 @@ func New() subscription.NewStore {
 		return &sub{
 			mu:       &sync.Mutex{},
 			memStore: memory.New(),
-			r:        red.New(config.Redis.Addr),
+			r:        red.New(config.Redis.Addr, red.StoreOptions(&config.Redis)...),
 		}, nil
 	}
 
@@ -108,7 +107,7 @@ func (s *sub) Init(ctx context.Context, clientIDs []string) error {
 			if err != nil {
 				return err
 			}
-			s.memStore.SubscribeLocked(ctx, strings.TrimLeft(clientId, subPrefix), sub)
+			s.memStore.SubscribeLocked(ctx, clientId, sub)
 		}
 	}
 	return nil
@@ -2,12 +2,25 @@ package mem
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/unack"
 )
 
 var _ unack.Store = (*Store)(nil)
 
+func init() {
+	persistence.RegisterUnackStore(persistence.Memory, NewStore())
+}
+
+// NewStore returns an unack.NewStore which creates memory unack stores.
+func NewStore() unack.NewStore {
+	return func(config *config.StoreType, clientId string) (unack.Store, error) {
+		return New(Options{ClientID: clientId}), nil
+	}
+}
+
 type Store struct {
 	clientID     string
 	unackpublish map[packet.Id]struct{}
@@ -39,7 +52,8 @@ func (s *Store) Set(_ context.Context, id packet.Id) (bool, error) {
 	return false, nil
 }
 
-func (s *Store) Remove(_ context.Context, id packet.Id) error {
+func (s *Store) Remove(_ context.Context, id packet.Id) (bool, error) {
+	_, ok := s.unackpublish[id]
 	delete(s.unackpublish, id)
-	return nil
+	return ok, nil
 }
@@ -2,7 +2,9 @@ package redis
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/unack"
 	red "github.com/yunqi/lighthouse/internal/redis"
 	"strconv"
@@ -15,6 +17,20 @@ const (
 
 var _ unack.Store = (*Store)(nil)
 
+func init() {
+	persistence.RegisterUnackStore(persistence.Redis, NewStore())
+}
+
+// NewStore returns an unack.NewStore which creates redis unack stores.
+func NewStore() unack.NewStore {
+	return func(config *config.StoreType, clientId string) (unack.Store, error) {
+		return New(Options{
+			ClientID: clientId,
+			R:        red.New(config.Redis.Addr, red.StoreOptions(&config.Redis)...),
+		}), nil
+	}
+}
+
 type Store struct {
 	key          string
 	clientID     string
@@ -48,6 +64,19 @@ func (s *Store) Init(ctx context.Context, cleanStart bool) error {
 		if err != nil {
 			return err
 		}
+		return nil
+	}
+	// load the unacknowledged ids of the previous connection.
+	rs, err := s.r.Hgetall(ctx, s.key)
+	if err != nil {
+		return err
+	}
+	for field := range rs {
+		id, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return err
+		}
+		s.unackpublish[packet.Id(id)] = struct{}{}
 	}
 	return nil
 }
@@ -69,11 +98,11 @@ func (s *Store) Set(ctx context.Context, id packet.Id) (bool, error) {
 	return false, nil
 }
 
-func (s *Store) Remove(ctx context.Context, id packet.Id) error {
-	_, err := s.r.Hdel(ctx, s.key, strconv.FormatUint(uint64(id), 10))
+func (s *Store) Remove(ctx context.Context, id packet.Id) (bool, error) {
+	ok, err := s.r.Hdel(ctx, s.key, strconv.FormatUint(uint64(id), 10))
 	if err != nil {
-		return err
+		return false, err
 	}
 	delete(s.unackpublish, id)
-	return nil
+	return ok, nil
 }
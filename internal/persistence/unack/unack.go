@@ -2,9 +2,13 @@ package unack
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
 )
 
+// NewStore creates a unack store of the client.
+type NewStore func(config *config.StoreType, clientId string) (Store, error)
+
 // Store represents a unack store for one client.
 // Unack store is used to persist the unacknowledged qos2 messages.
 type Store interface {
@@ -16,5 +20,6 @@ type Store interface {
 	// The return boolean indicates whether the id exist.
 	Set(ctx context.Context, id packet.Id) (bool, error)
 	// Remove removes the given id from store.
-	Remove(ctx context.Context, id packet.Id) error
+	// The return boolean indicates whether the id exist.
+	Remove(ctx context.Context, id packet.Id) (bool, error)
 }
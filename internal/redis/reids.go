@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	red "github.com/go-redis/redis/v8"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/breaker"
 	"io"
 	"time"
@@ -56,6 +57,38 @@ func WithClusterType() Option {
 	}
 }
 
+// WithPass sets the password of the redis server.
+func WithPass(pass string) Option {
+	return func(opt *option) {
+		opt.Pass = pass
+	}
+}
+
+// WithTimeout sets the timeout of the redis commands.
+func WithTimeout(timeout time.Duration) Option {
+	return func(opt *option) {
+		opt.Timeout = timeout
+	}
+}
+
+// StoreOptions returns the options of the redis client of the persistence store, such as the node type and the password.
+func StoreOptions(c *config.RedisStoreType) []Option {
+	var opts []Option
+	switch c.Type {
+	case NodeType:
+		opts = append(opts, WithNodeType())
+	case ClusterType:
+		opts = append(opts, WithClusterType())
+	}
+	if c.Password != "" {
+		opts = append(opts, WithPass(c.Password))
+	}
+	if c.Timeout != 0 {
+		opts = append(opts, WithTimeout(c.Timeout))
+	}
+	return opts
+}
+
 // New returns a Redis with given options.
 func New(addr string, opts ...Option) *Redis {
 	_option := new(option)
@@ -0,0 +1,25 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"testing"
+	"time"
+)
+
+func TestStoreOptions(t *testing.T) {
+	r := New("127.0.0.1:6379", StoreOptions(&config.RedisStoreType{
+		Type:     ClusterType,
+		Password: "pass",
+		Timeout:  time.Second,
+	})...)
+	assert.Equal(t, Type(ClusterType), r.option.Type)
+	assert.Equal(t, "pass", r.option.Pass)
+	assert.Equal(t, time.Second, r.option.Timeout)
+
+	// the defaults are kept if the options are absent.
+	r = New("127.0.0.1:6379", StoreOptions(&config.RedisStoreType{})...)
+	assert.Equal(t, Type(NodeType), r.option.Type)
+	assert.Empty(t, r.option.Pass)
+	assert.Equal(t, 20*time.Second, r.option.Timeout)
+}
@@ -0,0 +1,134 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"go.uber.org/zap"
+	"time"
+)
+
+// enhancedAuth performs the enhanced authentication with the Authentication Method in the CONNECT packet.
+// It returns the properties of the CONNACK packet if the authentication succeeds.
+// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901256
+func (c *client) enhancedAuth(ctx context.Context, conn *packet.Connect) (*packet.Properties, code.Code) {
+	logger := c.log.WithContext(ctx)
+	authMethod := string(conn.Properties.AuthMethod)
+	method, ok := c.server.authMethods[authMethod]
+	if !ok {
+		logger.Debug("unsupported authentication method", zap.String("method", authMethod))
+		return nil, code.BadAuthMethod
+	}
+	exchange := method.Start()
+	data := conn.Properties.AuthData
+	for {
+		resp, done, err := exchange.Next(data)
+		if err != nil {
+			logger.Debug("enhanced authentication failed", zap.String("method", authMethod), zap.Error(err))
+			return nil, code.NotAuthorized
+		}
+		props := &packet.Properties{AuthMethod: conn.Properties.AuthMethod, AuthData: resp}
+		if done {
+			// the user name of the connection is the authenticated one.
+			username := exchange.Username()
+			if len(conn.Username) != 0 && string(conn.Username) != username {
+				logger.Debug("user name mismatch", zap.String("username", string(conn.Username)), zap.String("authenticated", username))
+				return nil, code.NotAuthorized
+			}
+			conn.Username = []byte(username)
+			c.authMethod = authMethod
+			return props, code.Success
+		}
+		c.write(ctx, &packet.Auth{Version: conn.Version, Code: code.ContinueAuthentication, Properties: props})
+
+		_ = c.clientConn.SetReadDeadline(time.Now().Add(authTimeout))
+		p, err := c.packetReader.Read()
+		_ = c.clientConn.SetReadDeadline(time.Time{})
+		if err != nil {
+			logger.Debug("read auth packet", zap.Error(err))
+			return nil, code.UnspecifiedError
+		}
+		a, ok := p.(*packet.Auth)
+		// [MQTT-4.12.0-4]
+		if !ok || a.Code != code.ContinueAuthentication || authMethodOf(a) != authMethod {
+			logger.Debug("unexpected packet during authentication", zap.String("packet", p.String()))
+			return nil, code.ProtocolError
+		}
+		data = a.Properties.AuthData
+	}
+}
+
+// handleAuth handles the AUTH packet of the re-authentication.
+// The client can initiate a re-authentication at any time after the connection has been established,
+// it MUST use the same Authentication Method as in the CONNECT packet. [MQTT-4.12.1-1]
+func (c *client) handleAuth(a *packet.Auth) *xerror.Error {
+	ctx, span, logger := c.getTraceLog("auth")
+	defer span.End()
+	logger.Debug("received auth packet", zap.String("packet", a.String()))
+
+	if c.authMethod == "" || authMethodOf(a) != c.authMethod {
+		return xerror.NewError(code.ProtocolError)
+	}
+	switch a.Code {
+	case code.ReAuthenticate:
+		if c.authExchange != nil {
+			return xerror.NewError(code.ProtocolError)
+		}
+		c.authExchange = c.server.authMethods[c.authMethod].Start()
+	case code.ContinueAuthentication:
+		if c.authExchange == nil {
+			return xerror.NewError(code.ProtocolError)
+		}
+	default:
+		return xerror.NewError(code.ProtocolError)
+	}
+
+	resp, done, err := c.authExchange.Next(a.Properties.AuthData)
+	if err != nil {
+		logger.Debug("re-authentication failed", zap.Error(err))
+		// If the re-authentication fails, the Server SHOULD send DISCONNECT with an appropriate Reason Code, see handleConn.
+		return xerror.NewError(code.NotAuthorized)
+	}
+	reply := &packet.Auth{
+		Version:    c.version,
+		Code:       code.ContinueAuthentication,
+		Properties: &packet.Properties{AuthMethod: a.Properties.AuthMethod, AuthData: resp},
+	}
+	if done {
+		username := c.authExchange.Username()
+		c.authExchange = nil
+		// the client can not re-authenticate as another user.
+		if username != c.opt.Username {
+			logger.Debug("user name mismatch", zap.String("username", c.opt.Username), zap.String("authenticated", username))
+			return xerror.NewError(code.NotAuthorized)
+		}
+		reply.Code = code.Success
+	}
+	c.write(ctx, reply)
+	return nil
+}
+
+// authMethodOf returns the Authentication Method of the AUTH packet.
+func authMethodOf(a *packet.Auth) string {
+	if a.Properties == nil {
+		return ""
+	}
+	return string(a.Properties.AuthMethod)
+}
@@ -0,0 +1,106 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"net"
+	"testing"
+)
+
+// testAuthMethod authenticates the user whose name is the Authentication Data in two steps.
+type testAuthMethod struct{}
+
+func (testAuthMethod) Name() string { return "test" }
+
+func (testAuthMethod) Start() auth.Exchange { return &testAuthExchange{} }
+
+type testAuthExchange struct {
+	username string
+}
+
+func (e *testAuthExchange) Next(data []byte) ([]byte, bool, error) {
+	if e.username == "" {
+		e.username = string(data)
+		return []byte("continue"), false, nil
+	}
+	return nil, true, nil
+}
+
+func (e *testAuthExchange) Username() string {
+	return e.username
+}
+
+func TestClient_enhancedAuth(t *testing.T) {
+	method := []byte("test")
+	newConnect := func(username string) *packet.Connect {
+		connect := newTestConnect(packet.Version5, "a", true)
+		connect.Properties = &packet.Properties{AuthMethod: method, AuthData: []byte("user")}
+		if username != "" {
+			connect.UsernameFlag = true
+			connect.Username = []byte(username)
+		}
+		return connect
+	}
+	// newAuthClient returns the client which has received the CONNECT packet, the AUTH packet of the client is buffered.
+	newAuthClient := func(t *testing.T, connect *packet.Connect) *client {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, connect.Encode(buf))
+		assert.NoError(t, (&packet.Auth{
+			Version:    packet.Version5,
+			Code:       code.ContinueAuthentication,
+			Properties: &packet.Properties{AuthMethod: method},
+		}).Encode(buf))
+		conn, peer := net.Pipe()
+		t.Cleanup(func() {
+			_ = conn.Close()
+			_ = peer.Close()
+		})
+		c := newTestClient(t, "a")
+		c.server.authMethods = map[string]auth.Method{"test": testAuthMethod{}}
+		c.clientConn = conn
+		c.packetReader = packet.NewReader(buf)
+		_, err := c.packetReader.Read()
+		assert.NoError(t, err)
+		return c
+	}
+
+	t.Run("username from the exchange", func(t *testing.T) {
+		connect := newConnect("")
+		c := newAuthClient(t, connect)
+		props, cd := c.enhancedAuth(context.Background(), connect)
+		assert.Equal(t, code.Success, cd)
+		assert.Equal(t, method, props.AuthMethod)
+		assert.Equal(t, "user", string(connect.Username))
+		assert.Equal(t, "user", c.clientOption(connect, 0).Username)
+		if a, ok := (<-c.out).(*packet.Auth); assert.True(t, ok) {
+			assert.Equal(t, code.ContinueAuthentication, a.Code)
+		}
+	})
+
+	t.Run("username mismatch", func(t *testing.T) {
+		connect := newConnect("other")
+		c := newAuthClient(t, connect)
+		_, cd := c.enhancedAuth(context.Background(), connect)
+		assert.Equal(t, code.NotAuthorized, cd)
+	})
+}
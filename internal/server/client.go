@@ -21,12 +21,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/chenquan/go-pkg/xio"
+	"github.com/yunqi/lighthouse/internal/auth"
 	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/goroutine"
 	"github.com/yunqi/lighthouse/internal/packet"
 	"github.com/yunqi/lighthouse/internal/persistence/message"
 	"github.com/yunqi/lighthouse/internal/persistence/queue"
 	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
 	"github.com/yunqi/lighthouse/internal/session"
 	sub "github.com/yunqi/lighthouse/internal/subscription"
 	"github.com/yunqi/lighthouse/internal/xerror"
@@ -34,8 +36,10 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"io"
+	"math"
 	"net"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -46,6 +50,13 @@ const (
 	Connected
 )
 
+const (
+	// takeoverTimeout is the time to wait for the taken over client to send DISCONNECT before closing it.
+	takeoverTimeout = 5 * time.Second
+	// authTimeout is the time to wait for the AUTH packet of the client during the enhanced authentication.
+	authTimeout = 10 * time.Second
+)
+
 type (
 	Status byte
 	// Client represent a mqtt client.
@@ -109,39 +120,53 @@ type (
 		RequestProblemInfo bool
 	}
 	client struct {
-		clientId          string
-		connectedAt       int64
-		clientConn        net.Conn
-		bufReader         io.Reader
-		bufWriter         io.Writer
-		packetReader      *packet.Reader
-		packetWriter      *packet.Writer
-		status            Status
-		server            *server
-		in                chan packet.Packet
-		out               chan packet.Packet
-		session           *session.Session
-		cleanWillFlag     bool // whether to remove will Msg
-		version           packet.Version
-		opt               *ClientOption //set up before OnConnect()
-		disconnect        *packet.Disconnect
-		closed            chan struct{}
-		connected         chan struct{}
+		clientId      string
+		connectedAt   int64
+		clientConn    net.Conn
+		bufReader     io.Reader
+		bufWriter     io.Writer
+		packetReader  *packet.Reader
+		packetWriter  *packet.Writer
+		status        Status
+		server        *server
+		in            chan packet.Packet
+		out           chan packet.Packet
+		session       *session.Session
+		cleanWillFlag bool // whether to remove will Msg
+		version       packet.Version
+		opt           *ClientOption //set up before OnConnect()
+		disconnect    *packet.Disconnect
+		closed        chan struct{}
+		connected     chan struct{}
+		// done is closed when all goroutines of the client have exited.
+		done              chan struct{}
+		outMu             sync.RWMutex
+		outClosed         bool
 		wg                sync.WaitGroup
 		queueStore        queue.Queue
+		unackStore        unack.Store
 		subscriptionStore subscription.Store
 		limit             *packetIdLimiter
 		log               *xlog.Log
 		remoteAddr        net.Addr
+		// authMethod is the enhanced authentication method of the connection, empty means no enhanced authentication.
+		authMethod string
+		// authExchange is the ongoing re-authentication.
+		authExchange    auth.Exchange
+		inboundAliases  *inboundTopicAliases
+		outboundAliases *outboundTopicAliases
 	}
 )
 
 func (c *client) ClientOption() *ClientOption {
-	panic("implement me")
+	return c.opt
 }
 
 func (c *client) Deliver(message message.Message) error {
-	panic("implement me")
+	return c.queueStore.Add(context.Background(), &queue.Element{
+		At:      time.Now(),
+		Message: &queue.Publish{Message: &message},
+	})
 }
 
 func (c *client) ClientOptions() *ClientOption {
@@ -183,7 +208,50 @@ func (c *client) IsConnected() bool {
 func (c *client) IsConnecting() bool {
 	return c.status == Connecting
 }
+
+// Disconnect closes the client by the server.
+// The DISCONNECT packet is only sent to the v5 client, the connection of the v3 client is closed directly.
 func (c *client) Disconnect(disconnect *packet.Disconnect) {
+	if !packet.IsVersion5(c.version) {
+		_ = c.Close()
+		return
+	}
+	// the connection will be closed after the disconnect packet has been written, see writeConn.
+	c.write(context.Background(), disconnect)
+}
+
+// errorDisconnect returns the DISCONNECT packet which is sent by the server for the error.
+func (c *client) errorDisconnect(err *xerror.Error) *packet.Disconnect {
+	disconnect := &packet.Disconnect{Version: c.version, Code: err.Code}
+	if len(err.ReasonString) != 0 {
+		disconnect.Properties = &packet.Properties{ReasonString: err.ReasonString}
+	}
+	return disconnect
+}
+
+// willMessage returns the will message which is published after the network connection is closed,
+// or nil if the will message is absent or has been deleted by DISCONNECT.
+func (c *client) willMessage() *message.Message {
+	if c.cleanWillFlag || c.session == nil {
+		return nil
+	}
+	return c.session.Will
+}
+
+// takeover closes the client because a new connection with the same client id has been established,
+// it returns after all goroutines of the client have exited.
+func (c *client) takeover(ctx context.Context) {
+	c.log.WithContext(ctx).Info("session taken over", zap.String("clientId", c.clientId), zap.String("IP", c.remoteAddr.String()))
+	// [MQTT-3.1.4-3]
+	c.Disconnect(&packet.Disconnect{Version: c.version, Code: code.SessionTakenOver})
+	timer := time.NewTimer(takeoverTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+	case <-timer.C:
+		_ = c.Close()
+		<-c.done
+	}
 }
 
 func newClient(server *server, conn net.Conn) *client {
@@ -201,14 +269,17 @@ func newClient(server *server, conn net.Conn) *client {
 		out:               make(chan packet.Packet, 8),
 		closed:            make(chan struct{}),
 		connected:         make(chan struct{}),
+		done:              make(chan struct{}),
 		log:               xlog.LoggerModule("client"),
 		remoteAddr:        conn.RemoteAddr(),
 		subscriptionStore: server.subscriptionStore,
 	}
+	c.packetReader.SetMaxPacketSize(server.config.MaxPacketSize)
 	return c
 }
 
 func (c *client) listen() {
+	defer close(c.done)
 	ctx, span := c.server.tracer.Start(context.Background(), "listen")
 	logger := c.log.WithContext(ctx)
 	logger.Debug("create a new client connection", zap.Any("IP", c.remoteAddr.String()))
@@ -221,6 +292,9 @@ func (c *client) listen() {
 
 	// 认证
 	if !c.auth(ctx) {
+		// the connection is closed after the CONNACK packet has been written, see writeConn.
+		c.closeOut()
+		c.wg.Wait()
 		span.End()
 		return
 	}
@@ -272,6 +346,9 @@ func (c *client) auth(ctx context.Context) bool {
 		if err != io.EOF && p != nil {
 			logger.Error("read error", zap.String("packet_type", reflect.TypeOf(p).String()))
 		}
+		if _, ok := p.(*packet.Connect); ok {
+			c.rejectInvalidConnect(ctx, err)
+		}
 		select {
 		case <-c.closed:
 			logger.Debug("客户端退出，关闭连接")
@@ -282,7 +359,27 @@ func (c *client) auth(ctx context.Context) bool {
 	}
 
 	if connect, ok := p.(*packet.Connect); ok {
-		if !c.connectAuthentication(ctx, connect) {
+		if cd := c.checkClientId(connect); cd != code.Success {
+			logger.Debug("invalid client id", zap.String("IP", c.remoteAddr.String()))
+			c.rejectConnect(ctx, connect.Version, cd)
+			return false
+		}
+		if willProps := connect.WillProperties; connect.WillFlag && willProps != nil && willProps.PayloadFormat != nil &&
+			!c.server.validPayloadFormat(ctx, string(connect.ClientId), *willProps.PayloadFormat, connect.WillMessage) {
+			// the will message is validated as the PUBLISH packet is.
+			c.rejectConnect(ctx, connect.Version, code.PayloadFormatInvalid)
+			return false
+		}
+		var connackProps *packet.Properties
+		if packet.IsVersion5(connect.Version) && connect.Properties != nil && connect.Properties.AuthMethod != nil {
+			var cd code.Code
+			if connackProps, cd = c.enhancedAuth(ctx, connect); cd != code.Success {
+				logger.Debug("enhanced authentication failed", zap.String("IP", c.remoteAddr.String()))
+				c.rejectConnect(ctx, connect.Version, cd)
+				return false
+			}
+		}
+		if !c.connectAuthentication(ctx, connect, connackProps) {
 			logger.Debug("authentication failed", zap.String("IP", c.remoteAddr.String()))
 			return false
 		}
@@ -298,7 +395,6 @@ func (c *client) auth(ctx context.Context) bool {
 func (c *client) readConn() {
 	defer func() {
 		// 关闭 in 通道
-		_ = c.Close()
 		close(c.in)
 	}()
 	go func() {
@@ -324,9 +420,18 @@ func (c *client) readConn() {
 			select {
 			case <-c.closed:
 				c.log.Debug("客户端退出，关闭连接")
+				// the connection is closed after the pending packets such as DISCONNECT have been written, see writeConn.
+				return
 			default:
+				if cd, ok := readErrorCode(err); ok && packet.IsVersion5(c.version) {
+					c.log.Debug("read error", zap.String("clientId", c.clientId), zap.Error(err))
+					// the connection will be closed after the disconnect packet has been written, see writeConn.
+					c.Disconnect(&packet.Disconnect{Version: c.version, Code: cd})
+					return
+				}
 				c.log.Debug("连接超时，自动关闭")
 			}
+			_ = c.Close()
 			return
 		}
 		//if connect, ok := p.(*packet.Connect); ok {
@@ -342,77 +447,147 @@ func (c *client) readConn() {
 	}
 }
 
+// readErrorCode returns the reason code of the DISCONNECT packet for the error returned by packet.Reader.
+func readErrorCode(err error) (code.Code, bool) {
+	var e *xerror.Error
+	if errors.As(err, &e) {
+		return e.Code, true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		// no packet has been received in 1.5 times the Keep Alive time. [MQTT-3.1.2-22]
+		return code.KeepAliveTimeout, true
+	}
+	return 0, false
+}
+
 func (c *client) writeConn() {
 
 	defer func() {
+		// the connection is closed after all packets have been written.
+		_ = c.Close()
 	}()
 	for p := range c.out {
 		//c.log.Debug("Ret data", zap.String("packet", p.String()))
+		if publish, ok := p.(*packet.Publish); ok {
+			c.outboundAliases.apply(publish)
+		}
 		err := c.packetWriter.WritePacketAndFlush(p)
 		if err != nil {
 			return
 		}
+		if _, ok := p.(*packet.Disconnect); ok {
+			// the server closes the network connection after sending DISCONNECT. [MQTT-3.14.4-1]
+			_ = c.Close()
+		}
 	}
 	c.log.Debug("写入操作退出")
 
 }
 func (c *client) write(ctx context.Context, packet packet.Packet) {
 	c.log.WithContext(ctx).Debug("write packet", zap.String("packet", packet.String()))
-	c.out <- packet
+	c.outMu.RLock()
+	defer c.outMu.RUnlock()
+	if c.outClosed {
+		return
+	}
+	select {
+	case c.out <- packet:
+	case <-c.closed:
+	}
+}
+
+// closeOut closes the out channel, the packets written after closing are discarded.
+func (c *client) closeOut() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outClosed = true
+	close(c.out)
 }
 
 //func (c *client) waitConnection() {
 //	<-c.connected
 //}
 
+// connectionDone marks the client as connected, the packet id limiter is available after that.
 func (c *client) connectionDone() {
 	close(c.connected)
 }
 
-func (c *client) connection() (ok bool) {
-	defer func() {
-		c.connectionDone()
-	}()
-	timeout := time.NewTimer(5 * time.Second)
-	defer timeout.Stop()
-	for {
-		select {
-		case p := <-c.in:
-			//c.log.Debug("从in通道中读出数据", zap.Any("packet", p))
-			if p == nil {
-				return
-			}
-
-			switch conn := p.(type) {
-			case *packet.Connect:
-				if conn == nil {
-					//err := xerror.ErrProtocol
-					break
-				}
-				return c.connectAuthentication(context.Background(), conn)
-			default:
-			}
-		case <-timeout.C:
-			return
-		}
+// checkClientId returns the reason code of the CONNACK packet if the client id is rejected.
+// The client id can be empty only if config.Mqtt.AllowZeroLenClientId is true.
+func (c *client) checkClientId(conn *packet.Connect) code.Code {
+	if len(conn.ClientId) != 0 || c.server.config.AllowZeroLenClientId {
+		return code.Success
+	}
+	return code.ClientIdentifierNotValid
+}
 
+// rejectConnect sends the CONNACK packet with the code converted to the version of the client.
+// The connection is closed without CONNACK if the code can not be sent in the version. [MQTT-3.1.4-1]
+func (c *client) rejectConnect(ctx context.Context, version packet.Version, cd code.Code) {
+	cd, ok := packet.ConnackCode(version, cd)
+	if !ok {
+		return
 	}
+	c.write(ctx, &packet.Connack{Version: version, Code: cd})
+}
 
+// rejectInvalidConnect sends the CONNACK packet if the CONNECT packet failed to decode
+// because of the protocol version or the client id.
+func (c *client) rejectInvalidConnect(ctx context.Context, err error) {
+	var e *xerror.Error
+	if !errors.As(err, &e) {
+		return
+	}
+	switch e.Code {
+	case code.UnsupportedProtocolVersion:
+		c.rejectConnect(ctx, packet.Version5, e.Code)
+	case code.V3UnacceptableProtocolVersion:
+		// [MQTT-3.1.2-2]
+		c.rejectConnect(ctx, packet.Version311, e.Code)
+	case code.V3IdentifierRejected:
+		// v3 client with empty client id must set CleanSession to 1. [MQTT-3.1.3-8]
+		c.rejectConnect(ctx, packet.Version311, e.Code)
+	}
 }
 
 // TODO 验证客户端连接
 // connectAuthentication 连接验证
-func (c *client) connectAuthentication(ctx context.Context, conn *packet.Connect) (ok bool) {
+// connackProps is the properties of the CONNACK packet, such as the Authentication Data.
+func (c *client) connectAuthentication(ctx context.Context, conn *packet.Connect, connackProps *packet.Properties) (ok bool) {
 	logger := c.log.WithContext(ctx)
 
-	// 根据报文进行认证
-	var connack *packet.Connack
-	connack = conn.NewConnackPacket(code.Success, true)
 	c.clientId = string(conn.ClientId)
+	if c.clientId == "" {
+		// [MQTT-3.1.3-6]
+		c.clientId = c.server.newClientId()
+	}
+	c.version = conn.Version
 	logger.Debug("认证成功", zap.String("clientId", c.clientId))
 
+	// Takeover the connection which has the same client id. [MQTT-3.1.4-2]
+	if old := c.server.registerClient(c); old != nil {
+		old.takeover(ctx)
+		// The will message of the old connection is published only if the existing session ends. [MQTT-3.1.3-9]
+		if willMsg := old.willMessage(); willMsg != nil && conn.CleanSession {
+			c.server.publishWillMessage(ctx, c.clientId, willMsg)
+		}
+	}
+	defer func() {
+		if ok {
+			return
+		}
+		// the session of the failed connection is left to the session expiry sweeper.
+		c.server.unregisterClient(c)
+		c.rejectConnect(ctx, conn.Version, code.UnspecifiedError)
+	}()
+	// the expired session may be being removed, it must not be restored before the removal finishes.
+	c.server.waitSessionRemoved(c.clientId)
+
 	c.status = Connected
 	var msg *message.Message
+	var willDelayInterval uint32
 	if conn.WillFlag {
 		msg = &message.Message{
 			Dup:                    false,
@@ -428,50 +603,250 @@ func (c *client) connectAuthentication(ctx context.Context, conn *packet.Connect
 			ResponseTopic:          "",
 			SubscriptionIdentifier: nil,
 		}
+		if willProps := conn.WillProperties; willProps != nil {
+			if willProps.WillDelayInterval != nil {
+				willDelayInterval = *willProps.WillDelayInterval
+			}
+			if willProps.MessageExpiry != nil {
+				msg.MessageExpiry = *willProps.MessageExpiry
+			}
+			if willProps.PayloadFormat != nil {
+				msg.PayloadFormat = *willProps.PayloadFormat
+			}
+			msg.ContentType = string(willProps.ContentType)
+			msg.CorrelationData = willProps.CorrelationData
+			msg.ResponseTopic = string(willProps.ResponseTopic)
+		}
+	}
+	// A new connection of the session cancels the delayed will message. [MQTT-3.1.3-9]
+	// If the client connects with CleanStart, the existing session ends and the will message is published.
+	if willMsg := c.server.cancelWillMessage(c.clientId); willMsg != nil && conn.CleanSession {
+		c.server.publishWillMessage(ctx, c.clientId, willMsg)
+	}
+	oldSession, err := c.server.sessionStore.Get(ctx, c.clientId)
+	if err != nil {
+		logger.Error("get session", zap.Error(err))
+		return false
 	}
+	// [MQTT-3.2.2-2] [MQTT-3.2.2-3]
+	sessionPresent := !conn.CleanSession && oldSession != nil && oldSession.ClientId != ""
+
+	sessionExpiry := c.sessionExpiryInterval(conn)
 	c.session = &session.Session{
 		ClientId:          c.clientId,
 		Will:              msg,
-		WillDelayInterval: 0,
+		WillDelayInterval: willDelayInterval,
 		ConnectedAt:       time.Now(),
-		ExpiryInterval:    0,
+		ExpiryInterval:    sessionExpiry,
 	}
 	// client session
-	err := c.server.sessionStore.Set(ctx, c.session)
+	err = c.server.sessionStore.Set(ctx, c.session)
 	if err != nil {
-		logger.Panic("redis err", zap.Error(err))
+		logger.Error("set session", zap.Error(err))
+		return false
+	}
+	if conn.CleanSession {
+		// discard any existing session. [MQTT-3.1.2-6]
+		if err = c.subscriptionStore.UnsubscribeAll(ctx, c.clientId); err != nil {
+			logger.Error("unsubscribe all", zap.Error(err))
+			return false
+		}
+	} else {
+		// resume the subscriptions of the existing session. [MQTT-3.1.2-4]
+		if err = c.subscriptionStore.Init(ctx, []string{c.clientId}); err != nil {
+			logger.Error("init subscriptions", zap.Error(err))
+			return false
+		}
+		subscriptions := subscription.GetClientSubscriptions(ctx, c.subscriptionStore, c.clientId, subscription.TypeAll)
+		logger.Debug("resume subscriptions", zap.Any("subscriptions", subscriptions))
 	}
-	if !conn.CleanSession {
-		// 获取订阅记录
-		subscriptions := subscription.GetClientSubscriptions(ctx, c.server.subscriptionStore, string(conn.ClientId), subscription.TypeAll)
-
-		logger.Info("all subscriptions", zap.Any("subscriptions", subscriptions))
 
+	c.opt = c.clientOption(conn, sessionExpiry)
+	c.inboundAliases = newInboundTopicAliases(c.opt.ServerTopicAliasMax)
+	c.outboundAliases = newOutboundTopicAliases(c.opt.ClientTopicAliasMax)
+	queueStore, err := c.server.getQueueStore(c.clientId)
+	if err != nil {
+		logger.Error("get queue store", zap.Error(err))
+		return false
+	}
+	// the messages exceed the Maximum Packet Size of the client are dropped. [MQTT-3.1.2-24]
+	err = queueStore.Init(ctx, &queue.InitOptions{
+		CleanStart:     conn.CleanSession,
+		Version:        conn.Version,
+		ReadBytesLimit: c.opt.ClientMaxPacketSize,
+		Notifier:       newQueueNotifier(c.clientId),
+	})
+	if err != nil {
+		logger.Error("init queue store", zap.Error(err))
+		return false
 	}
+	c.queueStore = queueStore
 
-	c.version = conn.Version
-	c.opt = &ClientOption{
-		ClientId:  c.clientId,
-		Username:  string(conn.Username),
-		KeepAlive: conn.KeepAlive,
-		//SessionExpiry:       conn,
-		MaxInflight:         0,
-		ReceiveMax:          0,
-		ClientMaxPacketSize: 0,
-		ServerMaxPacketSize: 0,
-		ClientTopicAliasMax: 0,
-		ServerTopicAliasMax: 0,
-		RequestProblemInfo:  false,
+	unackStore, err := c.server.getUnackStore(c.clientId)
+	if err != nil {
+		logger.Error("get unack store", zap.Error(err))
+		return false
 	}
+	if err = unackStore.Init(ctx, conn.CleanSession); err != nil {
+		logger.Error("init unack store", zap.Error(err))
+		return false
+	}
+	c.unackStore = unackStore
+
 	c.newPacketIdLimiter(c.opt.MaxInflight)
+	connack := conn.NewConnackPacket(code.Success, sessionPresent)
+	if packet.IsVersion5(conn.Version) {
+		connack.Properties = c.connackProperties(conn, connackProps)
+	}
 	c.write(ctx, connack)
+	c.connectionDone()
 	return true
 }
 
+// sessionExpiryInterval returns the Session Expiry Interval of the client in seconds.
+// For v3 client, the session is kept for config.Mqtt.SessionExpiry if CleanSession is false.
+// For v5 client, it is the minimum of config.Mqtt.SessionExpiry and Session Expiry Interval property in CONNECT packet.
+func (c *client) sessionExpiryInterval(conn *packet.Connect) uint32 {
+	max := c.server.maxSessionExpiry()
+	if packet.IsVersion3(conn.Version) {
+		if conn.CleanSession {
+			return 0
+		}
+		return max
+	}
+	if conn.Properties == nil || conn.Properties.SessionExpiryInterval == nil {
+		return 0
+	}
+	if expiry := *conn.Properties.SessionExpiryInterval; expiry < max {
+		return expiry
+	}
+	return max
+}
+
+// clientOption negotiates the limits of the connection from the CONNECT packet and config.Mqtt.
+func (c *client) clientOption(conn *packet.Connect, sessionExpiry uint32) *ClientOption {
+	cfg := c.server.config
+	opt := &ClientOption{
+		ClientId:      c.clientId,
+		Username:      string(conn.Username),
+		KeepAlive:     conn.KeepAlive,
+		SessionExpiry: sessionExpiry,
+		MaxInflight:   cfg.MaxInflight,
+		ReceiveMax:    cfg.ReceiveMax,
+		// The Maximum Packet Size is unlimited if it is absent.
+		ClientMaxPacketSize: packet.MaximumSize,
+		ServerMaxPacketSize: cfg.MaxPacketSize,
+	}
+	if opt.MaxInflight == 0 {
+		opt.MaxInflight = math.MaxUint16
+	}
+	if opt.ReceiveMax == 0 {
+		opt.ReceiveMax = math.MaxUint16
+	}
+	if opt.ServerMaxPacketSize == 0 || opt.ServerMaxPacketSize > packet.MaximumSize {
+		opt.ServerMaxPacketSize = packet.MaximumSize
+	}
+	if cfg.MaxKeepAlive != 0 && opt.KeepAlive > cfg.MaxKeepAlive {
+		opt.KeepAlive = cfg.MaxKeepAlive
+	}
+	if !packet.IsVersion5(conn.Version) {
+		return opt
+	}
+	opt.ServerTopicAliasMax = cfg.TopicAliasMax
+	// The Request Problem Information is 1 if it is absent.
+	opt.RequestProblemInfo = true
+	if props := conn.Properties; props != nil {
+		if props.ReceiveMaximum != nil && *props.ReceiveMaximum < opt.MaxInflight {
+			opt.MaxInflight = *props.ReceiveMaximum
+		}
+		if props.MaximumPacketSize != nil {
+			opt.ClientMaxPacketSize = *props.MaximumPacketSize
+		}
+		if props.TopicAliasMaximum != nil {
+			opt.ClientTopicAliasMax = *props.TopicAliasMaximum
+		}
+		if props.RequestProblemInfo != nil {
+			opt.RequestProblemInfo = *props.RequestProblemInfo == 1
+		}
+	}
+	return opt
+}
+
+// connackProperties returns the properties of the CONNACK packet which advertise the limits of the server.
+// props is the properties set by the enhanced authentication, it can be nil.
+// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901080
+func (c *client) connackProperties(conn *packet.Connect, props *packet.Properties) *packet.Properties {
+	if props == nil {
+		props = &packet.Properties{}
+	}
+	cfg := c.server.config
+	boolByte := func(b bool) *byte {
+		var v byte
+		if b {
+			v = 1
+		}
+		return &v
+	}
+	if c.opt.ReceiveMax != math.MaxUint16 {
+		props.ReceiveMaximum = &c.opt.ReceiveMax
+	}
+	// The Maximum QoS can only be 0 or 1, its absence means QoS 2 is supported.
+	if cfg.MaximumQoS < packet.QoS2 {
+		props.MaximumQoS = &cfg.MaximumQoS
+	}
+	props.RetainAvailable = boolByte(cfg.RetainAvailable)
+	props.WildcardSubAvailable = boolByte(cfg.WildcardAvailable)
+	props.SubIDAvailable = boolByte(cfg.SubscriptionIDAvailable)
+	props.SharedSubAvailable = boolByte(cfg.SharedSubAvailable)
+	if c.opt.ServerMaxPacketSize != packet.MaximumSize {
+		props.MaximumPacketSize = &c.opt.ServerMaxPacketSize
+	}
+	if c.opt.ServerTopicAliasMax != 0 {
+		props.TopicAliasMaximum = &c.opt.ServerTopicAliasMax
+	}
+	// The client MUST use the Server Keep Alive instead of the value it sent. [MQTT-3.2.2-21]
+	if c.opt.KeepAlive != conn.KeepAlive {
+		props.ServerKeepAlive = &c.opt.KeepAlive
+	}
+	// [MQTT-3.2.2-16]
+	if len(conn.ClientId) == 0 {
+		props.AssignedClientID = []byte(c.clientId)
+	}
+	// The server informs the client that it is using a Session Expiry Interval other than that sent by the client.
+	var sessionExpiry uint32
+	if conn.Properties != nil && conn.Properties.SessionExpiryInterval != nil {
+		sessionExpiry = *conn.Properties.SessionExpiryInterval
+	}
+	if sessionExpiry != c.opt.SessionExpiry {
+		props.SessionExpiryInterval = &c.opt.SessionExpiry
+	}
+	return props
+}
+
 func (c *client) handleConn() {
 	defer func() {
 		close(c.closed)
-		close(c.out)
+		c.closeOut()
+		online := c.server.unregisterClient(c)
+		// unblock readConn
+		go func() {
+			for range c.in {
+			}
+		}()
+		// the session is kept by the new connection if the client has been taken over,
+		// the will message is handled by the new connection too, see connectAuthentication.
+		if online {
+			// The will message is published when the network connection is closed without a normal DISCONNECT.
+			if willMsg := c.willMessage(); willMsg != nil {
+				c.server.addWillMessage(c.clientId, willMsg, c.session.WillDelayInterval)
+			}
+			c.server.sessionOffline(context.Background(), c.clientId, c.opt.SessionExpiry)
+		}
+		// unblock pollMessageHandler
+		c.limit.close()
+		if err := c.queueStore.Close(); err != nil {
+			c.log.Error("close queue store", zap.Error(err))
+		}
 	}()
 	var err *xerror.Error
 	// in 通道关闭时，自动退出
@@ -481,21 +856,63 @@ func (c *client) handleConn() {
 			err = c.handlePublish(packetData)
 		case *packet.Pingreq:
 			c.handlePingreq(packetData)
+		case *packet.Puback:
+			c.handlePuback(packetData)
+		case *packet.Pubrec:
+			c.handlePubrec(packetData)
 		case *packet.Pubrel:
 			c.handlePubrel(packetData)
+		case *packet.Pubcomp:
+			c.handlePubcomp(packetData)
 		case *packet.Subscribe:
 			c.handleSubscribe(packetData)
 		case *packet.Unsubscribe:
 			c.handleUnsubscribe(packetData)
+		case *packet.Auth:
+			err = c.handleAuth(packetData)
 		case *packet.Disconnect:
-			break
+			if err = c.handleDisconnect(packetData); err == nil {
+				return
+			}
 		default:
 		}
 		if err != nil {
+			c.Disconnect(c.errorDisconnect(err))
 			break
 		}
 	}
 }
+
+func (c *client) handleDisconnect(disconnect *packet.Disconnect) *xerror.Error {
+	ctx, span, logger := c.getTraceLog("disconnect")
+	defer span.End()
+	logger.Debug("received disconnect packet", zap.String("packet", disconnect.String()))
+
+	c.disconnect = disconnect
+	if props := disconnect.Properties; props != nil && props.SessionExpiryInterval != nil {
+		expiry := *props.SessionExpiryInterval
+		if c.opt.SessionExpiry == 0 && expiry != 0 {
+			// It is a Protocol Error to set a non-zero Session Expiry Interval
+			// if the Session Expiry Interval in the CONNECT packet was zero. [MQTT-3.14.2-2]
+			logger.Debug("invalid session expiry interval", zap.Uint32("sessionExpiry", expiry))
+			return xerror.ErrProtocol
+		}
+		if max := c.server.maxSessionExpiry(); expiry > max {
+			expiry = max
+		}
+		c.opt.SessionExpiry = expiry
+		c.session.ExpiryInterval = expiry
+		if err := c.server.sessionStore.SetSessionExpiry(ctx, c.clientId, expiry); err != nil {
+			logger.Error("set session expiry", zap.Error(err))
+		}
+	}
+	// The will message is deleted from the session on receipt of a DISCONNECT packet,
+	// unless the Reason Code is 0x04 (Disconnect with Will Message). [MQTT-3.1.2-10]
+	if packet.IsVersion3(c.version) || disconnect.Code != code.DisconnectWithWillMessage {
+		c.cleanWillFlag = true
+	}
+	return nil
+}
 func (c *client) getTraceLog(spanName string) (context.Context, trace.Span, *zap.Logger) {
 	ctx, span := c.server.tracer.Start(context.Background(), spanName)
 	logger := c.log.WithContext(ctx)
@@ -505,6 +922,36 @@ func (c *client) handlePublish(publish *packet.Publish) *xerror.Error {
 	ctx, span, logger := c.getTraceLog("publish")
 	defer span.End()
 	logger.Debug("received publish packet", zap.String("packet", publish.String()))
+	if err := c.inboundAliases.resolve(publish); err != nil {
+		logger.Debug("invalid topic alias", zap.String("packet", publish.String()))
+		return err
+	}
+	msg := message.FromPublish(publish)
+	if msg.Retained && !c.server.config.RetainAvailable {
+		logger.Debug("retain not supported", zap.String("topic", msg.Topic))
+		return xerror.NewError(code.RetainNotSupported)
+	}
+	if !c.server.validPayloadFormat(ctx, c.clientId, msg.PayloadFormat, msg.Payload) {
+		return c.rejectPublish(ctx, publish, code.PayloadFormatInvalid)
+	}
+
+	var exist bool
+	if publish.QoS == packet.QoS2 {
+		var err error
+		exist, err = c.unackStore.Set(ctx, publish.PacketId)
+		if err != nil {
+			logger.Error("set unack", zap.Uint16("packetId", publish.PacketId), zap.Error(err))
+			return xerror.NewError(code.UnspecifiedError)
+		}
+	}
+	// The retransmitted QoS 2 message must not be delivered again before the PUBREL. [MQTT-4.3.3-10]
+	if !exist {
+		if msg.Retained {
+			c.server.retainMessage(ctx, msg)
+		}
+		c.server.deliverMessage(ctx, c.clientId, msg)
+	}
+
 	var ackPacket packet.Packet
 	switch publish.QoS {
 	case packet.QoS1:
@@ -521,6 +968,24 @@ func (c *client) handlePublish(publish *packet.Publish) *xerror.Error {
 	return nil
 }
 
+// rejectPublish rejects the PUBLISH packet by the PUBACK or PUBREC packet with the reason code.
+// The QoS 0 message has no acknowledgement, so the error is returned to disconnect the client.
+func (c *client) rejectPublish(ctx context.Context, publish *packet.Publish, cd code.Code) *xerror.Error {
+	switch publish.QoS {
+	case packet.QoS1:
+		puback := publish.CreatePuback()
+		puback.Code = cd
+		c.write(ctx, puback)
+	case packet.QoS2:
+		pubrec := publish.CreatePubrec()
+		pubrec.Code = cd
+		c.write(ctx, pubrec)
+	default:
+		return xerror.NewError(cd)
+	}
+	return nil
+}
+
 func (c *client) handlePingreq(pingreq *packet.Pingreq) {
 	ctx, span, logger := c.getTraceLog("ping request")
 	defer span.End()
@@ -533,7 +998,66 @@ func (c *client) handlePubrel(pubrel *packet.Pubrel) {
 	defer span.End()
 
 	logger.Debug("received publish release packet", zap.String("packet", pubrel.String()))
-	c.write(ctx, pubrel.CreatePubcomp())
+	pubcomp := pubrel.CreatePubcomp()
+	exist, err := c.unackStore.Remove(ctx, pubrel.PacketId)
+	if err != nil {
+		logger.Error("remove unack", zap.Uint16("packetId", pubrel.PacketId), zap.Error(err))
+		pubcomp.Code = code.UnspecifiedError
+	} else if !exist {
+		pubcomp.Code = code.PacketIDNotFound
+	}
+	if packet.IsVersion3(c.version) {
+		pubcomp.Code = code.Success
+	}
+	c.write(ctx, pubcomp)
+}
+
+// handlePuback completes the delivery of the outbound QoS 1 message.
+func (c *client) handlePuback(puback *packet.Puback) {
+	ctx, span, logger := c.getTraceLog("publish ack")
+	defer span.End()
+
+	logger.Debug("received publish ack packet", zap.String("packet", puback.String()))
+	c.completeInflight(ctx, logger, puback.PacketId)
+}
+
+// handlePubrec replaces the outbound QoS 2 message with PUBREL and sends the PUBREL.
+func (c *client) handlePubrec(pubrec *packet.Pubrec) {
+	ctx, span, logger := c.getTraceLog("publish received")
+	defer span.End()
+
+	logger.Debug("received publish received packet", zap.String("packet", pubrec.String()))
+	// The delivery is completed if the Reason Code of PUBREC is 0x80 or greater. [MQTT-4.3.3-4]
+	if packet.IsVersion5(c.version) && pubrec.Code >= code.UnspecifiedError {
+		c.completeInflight(ctx, logger, pubrec.PacketId)
+		return
+	}
+	pubrel := pubrec.CreateNewPubrel()
+	_, err := c.queueStore.Replace(ctx, &queue.Element{
+		At:      time.Now(),
+		Message: &queue.Pubrel{PacketID: pubrec.PacketId},
+	})
+	if err != nil {
+		logger.Error("replace pubrel", zap.Uint16("packetId", pubrec.PacketId), zap.Error(err))
+	}
+	c.write(ctx, pubrel)
+}
+
+// handlePubcomp completes the delivery of the outbound QoS 2 message.
+func (c *client) handlePubcomp(pubcomp *packet.Pubcomp) {
+	ctx, span, logger := c.getTraceLog("publish complete")
+	defer span.End()
+
+	logger.Debug("received publish complete packet", zap.String("packet", pubcomp.String()))
+	c.completeInflight(ctx, logger, pubcomp.PacketId)
+}
+
+// completeInflight removes the inflight message from the queue and releases the packet id.
+func (c *client) completeInflight(ctx context.Context, logger *zap.Logger, packetId packet.Id) {
+	if err := c.queueStore.Remove(ctx, packetId); err != nil {
+		logger.Error("remove inflight", zap.Uint16("packetId", packetId), zap.Error(err))
+	}
+	c.limit.release(packetId)
 }
 
 func (c *client) handleSubscribe(subscribe *packet.Subscribe) {
@@ -542,43 +1066,154 @@ func (c *client) handleSubscribe(subscribe *packet.Subscribe) {
 
 	logger.Debug("received subscribe packet", zap.String("packet", subscribe.String()))
 
-	var subs = make([]*sub.Subscription, 0, len(subscribe.Topics))
+	var subscriptionId uint32
+	if p := subscribe.Properties; packet.IsVersion5(c.version) && p != nil && len(p.SubscriptionIdentifier) != 0 {
+		subscriptionId = p.SubscriptionIdentifier[0]
+	}
 
-	for _, topic := range subscribe.Topics {
-		subs = append(subs, &sub.Subscription{
-			//ShareName:         topic.Name,
-			TopicFilter: topic.Name,
-			//ID:                subscribe.PacketId,
-			QoS:               topic.QoS,
-			NoLocal:           topic.NoLocal,
-			RetainAsPublished: topic.RetainAsPublished,
-			RetainHandling:    topic.RetainHandling,
-		})
+	codes := make([]code.Code, len(subscribe.Topics))
+	subs := make([]*sub.Subscription, 0, len(subscribe.Topics))
+	// indexes stores the index in codes of each subscription in subs.
+	indexes := make([]int, 0, len(subscribe.Topics))
+	var quota *subscriptionQuota
+	if max := c.server.config.MaxSubscriptions; max > 0 {
+		quota = newSubscriptionQuota(ctx, c.subscriptionStore, c.clientId, max)
+	}
+	for i, topic := range subscribe.Topics {
+		s := subscription.FromTopic(*topic, subscriptionId)
+		if cd := c.checkSubscription(ctx, s, subscriptionId); cd != code.Success {
+			logger.Debug("subscription rejected", zap.String("topic", topic.Name), zap.Uint8("code", cd))
+			codes[i] = c.subackCode(cd)
+			continue
+		}
+		if quota != nil && !quota.take(s) {
+			logger.Debug("subscription quota exceeded", zap.String("topic", topic.Name))
+			codes[i] = c.subackCode(code.QuotaExceeded)
+			continue
+		}
+		// The granted QoS is the maximum QoS supported by the server. [MQTT-3.8.4-8]
+		if s.QoS > c.server.config.MaximumQoS {
+			s.QoS = c.server.config.MaximumQoS
+		}
+		codes[i] = s.QoS
+		subs = append(subs, s)
+		indexes = append(indexes, i)
 	}
-	subscribeResult, err := c.subscriptionStore.Subscribe(ctx, c.clientId, subs...)
-	if err != nil {
-		logger.Error("err", zap.Error(err))
-		return
 
-	} else {
-		logger.Info("", zap.Any("subscribeResult", subscribeResult))
+	if len(subs) != 0 {
+		subscribeResult, err := c.subscriptionStore.Subscribe(ctx, c.clientId, subs...)
+		if err != nil {
+			logger.Error("subscribe", zap.Error(err))
+			for _, i := range indexes {
+				codes[i] = c.subackCode(code.UnspecifiedError)
+			}
+		} else if c.server.config.RetainAvailable {
+			for _, result := range subscribeResult {
+				c.server.deliverRetainedMessages(ctx, c.clientId, result.Subscription, result.AlreadyExisted)
+			}
+		}
 	}
 	c.write(ctx, &packet.Suback{
 		Version:  subscribe.Version,
 		PacketId: subscribe.PacketId,
-		Payload:  make([]code.Code, len(subscribe.Topics)),
+		Payload:  codes,
 	})
 }
 
+// checkSubscription returns the reason code of the subscription, code.Success means the subscription is accepted.
+func (c *client) checkSubscription(ctx context.Context, s *sub.Subscription, subscriptionId uint32) code.Code {
+	if err := s.Validate(); err != nil {
+		return code.TopicFilterInvalid
+	}
+	if s.ShareName != "" {
+		if !c.server.config.SharedSubAvailable {
+			return code.SharedSubNotSupported
+		}
+		// It is a Protocol Error to set the No Local bit to 1 on a Shared Subscription. [MQTT-3.8.3-4]
+		if s.NoLocal {
+			return code.TopicFilterInvalid
+		}
+	}
+	if !c.server.config.WildcardAvailable && strings.ContainsAny(s.TopicFilter, "+#") {
+		return code.WildcardSubNotSupported
+	}
+	if subscriptionId != 0 && !c.server.config.SubscriptionIDAvailable {
+		return code.SubIDNotSupported
+	}
+	if authorizer := c.server.subscribeAuthorizer; authorizer != nil && !authorizer(ctx, c.clientId, s) {
+		return code.NotAuthorized
+	}
+	return code.Success
+}
+
+// subackCode converts the reason code to the return code of the SUBACK for the client version.
+// The failure return code of v3 is 0x80.
+func (c *client) subackCode(cd code.Code) code.Code {
+	if packet.IsVersion3(c.version) && cd >= code.UnspecifiedError {
+		return code.V3SubackFailure
+	}
+	return cd
+}
+
+// subscriptionQuota limits the number of subscriptions of the client.
+type subscriptionQuota struct {
+	max      int
+	existing map[string]struct{}
+}
+
+func newSubscriptionQuota(ctx context.Context, store subscription.Store, clientId string, max int) *subscriptionQuota {
+	q := &subscriptionQuota{max: max, existing: make(map[string]struct{})}
+	for _, s := range subscription.GetClientSubscriptions(ctx, store, clientId, subscription.TypeAll) {
+		q.existing[s.GetFullTopicName()] = struct{}{}
+	}
+	return q
+}
+
+// take returns whether there is quota for the subscription.
+// Replacing an existing subscription does not take any quota.
+func (q *subscriptionQuota) take(s *sub.Subscription) bool {
+	name := s.GetFullTopicName()
+	if _, ok := q.existing[name]; ok {
+		return true
+	}
+	if len(q.existing) >= q.max {
+		return false
+	}
+	q.existing[name] = struct{}{}
+	return true
+}
+
 func (c *client) handleUnsubscribe(unsubscribe *packet.Unsubscribe) {
 	ctx, span, logger := c.getTraceLog("unsubscribe")
 	defer span.End()
 	logger.Debug("received unsubscribe packet", zap.String("packet", unsubscribe.String()))
 
-	c.write(ctx, &packet.Unsuback{
+	existing := make(map[string]struct{})
+	for _, s := range subscription.GetClientSubscriptions(ctx, c.subscriptionStore, c.clientId, subscription.TypeAll) {
+		existing[s.GetFullTopicName()] = struct{}{}
+	}
+	codes := make([]code.Code, len(unsubscribe.Topics))
+	for i, topic := range unsubscribe.Topics {
+		if _, ok := existing[topic]; !ok {
+			codes[i] = code.NoSubscriptionExisted
+		}
+	}
+	if err := c.subscriptionStore.Unsubscribe(ctx, c.clientId, unsubscribe.Topics...); err != nil {
+		logger.Error("unsubscribe", zap.Error(err))
+		for i := range codes {
+			codes[i] = code.UnspecifiedError
+		}
+	}
+
+	unsuback := &packet.Unsuback{
 		Version:  unsubscribe.Version,
 		PacketId: unsubscribe.PacketId,
-	})
+	}
+	// The UNSUBACK of v3 has no payload.
+	if packet.IsVersion5(c.version) {
+		unsuback.Payload = codes
+	}
+	c.write(ctx, unsuback)
 }
 
 func (c *client) pollMessageHandler() {
@@ -627,7 +1262,7 @@ func (c *client) pollNewMessages(ids []packet.Id) (unused []packet.Id, err error
 			if m.QoS != packet.QoS0 {
 				ids = ids[1:]
 			}
-			c.write(context.Background(), message.ToPublish(m.Message, c.version))
+			c.write(context.Background(), c.publishPacket(v, m.Message, time.Now()))
 		case *queue.Pubrel:
 		}
 	}
@@ -643,18 +1278,47 @@ func (c *client) pollInFlights() (bool, error) {
 	defer c.limit.unlock()
 	for _, elem := range elems {
 		id := elem.Message.Id()
+		c.limit.markUsedLocked(id)
 		switch m := elem.Message.(type) {
 		case *queue.Publish:
 			m.Dup = true
-
-			m.SubscriptionIdentifier = nil
-			c.limit.markUsedLocked(id)
-			c.write(context.Background(), message.ToPublish(m.Message, c.version))
+			c.write(context.Background(), c.publishPacket(elem, m.Message, time.Now()))
 		case *queue.Pubrel:
-			c.write(context.Background(), &packet.Pubrel{PacketId: id})
+			c.write(context.Background(), &packet.Pubrel{PacketId: id, Version: c.version})
 		}
 	}
-	return false, nil
+	// keep reading until all inflight messages have been resent.
+	return true, nil
+}
+
+// publishPacket returns the PUBLISH packet of the message in the queue.
+// The Message Expiry Interval is set to the received value minus the time that the message has been waiting in the queue. [MQTT-3.3.2-6]
+func (c *client) publishPacket(elem *queue.Element, msg *message.Message, now time.Time) *packet.Publish {
+	publish := message.ToPublish(msg, c.version)
+	if publish.Properties == nil || publish.Properties.MessageExpiry == nil {
+		return publish
+	}
+	// the inflight message is sent even if it has expired.
+	remaining := uint32(1)
+	if waited := uint32(now.Sub(elem.At) / time.Second); waited < msg.MessageExpiry {
+		remaining = msg.MessageExpiry - waited
+	}
+	// the message in the queue is not changed, it may be resent.
+	publish.Properties.MessageExpiry = &remaining
+	return publish
+}
+
+// inflightCount returns the number of inflight messages of the client.
+func (c *client) inflightCount() int {
+	select {
+	case <-c.connected:
+	default:
+		return 0
+	}
+	if c.limit == nil {
+		return 0
+	}
+	return int(c.limit.usedCount())
 }
 
 func (c *client) newPacketIdLimiter(limit uint16) {
@@ -0,0 +1,686 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	sessionMemory "github.com/yunqi/lighthouse/internal/persistence/session/memory"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
+	unackMem "github.com/yunqi/lighthouse/internal/persistence/unack/mem"
+	"github.com/yunqi/lighthouse/internal/session"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"github.com/yunqi/lighthouse/internal/xtrace"
+	"go.opentelemetry.io/otel"
+	"io"
+	"math"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClient_checkSubscription(t *testing.T) {
+	mqtt := config.DefaultMqtt
+	mqtt.SharedSubAvailable = false
+	mqtt.SubscriptionIDAvailable = false
+	c := &client{server: &server{
+		config: &mqtt,
+		subscribeAuthorizer: func(ctx context.Context, clientId string, subscription *sub.Subscription) bool {
+			return subscription.TopicFilter != "deny"
+		},
+	}}
+	ctx := context.Background()
+	for name, tt := range map[string]struct {
+		topic          string
+		subscriptionId uint32
+		want           code.Code
+	}{
+		"success":       {topic: "a/+", want: code.Success},
+		"invalid":       {topic: "a/#/b", want: code.TopicFilterInvalid},
+		"shared":        {topic: "$share/g/a", want: code.SharedSubNotSupported},
+		"sub id":        {topic: "a", subscriptionId: 1, want: code.SubIDNotSupported},
+		"authorization": {topic: "deny", want: code.NotAuthorized},
+	} {
+		s := subscription.FromTopic(packet.Topic{Name: tt.topic}, tt.subscriptionId)
+		assert.Equal(t, tt.want, c.checkSubscription(ctx, s, tt.subscriptionId), name)
+	}
+
+	c.version = packet.Version311
+	assert.Equal(t, code.V3SubackFailure, c.subackCode(code.NotAuthorized))
+	assert.Equal(t, code.GrantedQoS1, c.subackCode(code.GrantedQoS1))
+	c.version = packet.Version5
+	assert.Equal(t, code.NotAuthorized, c.subackCode(code.NotAuthorized))
+}
+
+func TestSubscriptionQuota(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	_, err := store.Subscribe(ctx, "c", &sub.Subscription{TopicFilter: "a"})
+	assert.NoError(t, err)
+
+	quota := newSubscriptionQuota(ctx, store, "c", 2)
+	assert.True(t, quota.take(&sub.Subscription{TopicFilter: "a"}))
+	assert.True(t, quota.take(&sub.Subscription{TopicFilter: "b"}))
+	assert.False(t, quota.take(&sub.Subscription{TopicFilter: "c"}))
+	assert.True(t, quota.take(&sub.Subscription{TopicFilter: "b"}))
+}
+
+func TestClient_clientOption(t *testing.T) {
+	mqtt := config.DefaultMqtt
+	mqtt.MaxInflight = 20
+	mqtt.MaxKeepAlive = 60
+	mqtt.MaxPacketSize = 1024
+	mqtt.MaximumQoS = 1
+	mqtt.WildcardAvailable = false
+	c := &client{server: &server{config: &mqtt}}
+
+	t.Run("v311", func(t *testing.T) {
+		opt := c.clientOption(&packet.Connect{Version: packet.Version311, KeepAlive: 120}, 0)
+		assert.Equal(t, uint16(20), opt.MaxInflight)
+		assert.Equal(t, uint16(60), opt.KeepAlive)
+		assert.Equal(t, uint32(1024), opt.ServerMaxPacketSize)
+		assert.Equal(t, uint32(packet.MaximumSize), opt.ClientMaxPacketSize)
+		assert.Zero(t, opt.ServerTopicAliasMax)
+		assert.False(t, opt.RequestProblemInfo)
+	})
+
+	t.Run("v5", func(t *testing.T) {
+		receiveMax, topicAliasMax, maxPacketSize, problemInfo := uint16(5), uint16(3), uint32(512), byte(0)
+		conn := &packet.Connect{Version: packet.Version5, KeepAlive: 30, Properties: &packet.Properties{
+			ReceiveMaximum:     &receiveMax,
+			TopicAliasMaximum:  &topicAliasMax,
+			MaximumPacketSize:  &maxPacketSize,
+			RequestProblemInfo: &problemInfo,
+		}}
+		c.opt = c.clientOption(conn, 0)
+		assert.Equal(t, &ClientOption{
+			KeepAlive:           30,
+			MaxInflight:         5,
+			ReceiveMax:          mqtt.ReceiveMax,
+			ClientMaxPacketSize: 512,
+			ServerMaxPacketSize: 1024,
+			ClientTopicAliasMax: 3,
+			ServerTopicAliasMax: mqtt.TopicAliasMax,
+		}, c.opt)
+
+		props := c.connackProperties(conn, nil)
+		assert.Equal(t, mqtt.ReceiveMax, *props.ReceiveMaximum)
+		assert.Equal(t, byte(1), *props.MaximumQoS)
+		assert.Equal(t, byte(0), *props.WildcardSubAvailable)
+		assert.Equal(t, byte(1), *props.RetainAvailable)
+		assert.Equal(t, uint32(1024), *props.MaximumPacketSize)
+		assert.Equal(t, mqtt.TopicAliasMax, *props.TopicAliasMaximum)
+		assert.Nil(t, props.ServerKeepAlive)
+		assert.Nil(t, props.SessionExpiryInterval)
+
+		conn.KeepAlive = 0
+		c.opt = c.clientOption(conn, 0)
+		assert.Nil(t, c.connackProperties(conn, nil).ServerKeepAlive)
+		conn.KeepAlive = 100
+		c.opt = c.clientOption(conn, 0)
+		assert.Equal(t, uint16(60), *c.connackProperties(conn, nil).ServerKeepAlive)
+	})
+}
+
+func TestClient_publishPacket(t *testing.T) {
+	c := &client{version: packet.Version5}
+	now := time.Now()
+	elem := &queue.Element{At: now.Add(-3 * time.Second)}
+
+	msg := &message.Message{Topic: "a", MessageExpiry: 10}
+	publish := c.publishPacket(elem, msg, now)
+	assert.Equal(t, uint32(7), *publish.Properties.MessageExpiry)
+	assert.Equal(t, uint32(10), msg.MessageExpiry)
+
+	msg = &message.Message{Topic: "a", MessageExpiry: 2}
+	assert.Equal(t, uint32(1), *c.publishPacket(elem, msg, now).Properties.MessageExpiry)
+
+	publish = c.publishPacket(elem, &message.Message{Topic: "a"}, now)
+	assert.Nil(t, publish.Properties.MessageExpiry)
+
+	c.version = packet.Version311
+	assert.Nil(t, c.publishPacket(elem, &message.Message{Topic: "a", MessageExpiry: 10}, now).Properties)
+}
+
+func TestReadErrorCode(t *testing.T) {
+	cd, ok := readErrorCode(xerror.ErrMalformed)
+	assert.True(t, ok)
+	assert.Equal(t, code.MalformedPacket, cd)
+
+	cd, ok = readErrorCode(&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded})
+	assert.True(t, ok)
+	assert.Equal(t, code.KeepAliveTimeout, cd)
+
+	_, ok = readErrorCode(io.EOF)
+	assert.False(t, ok)
+}
+
+func TestClient_errorDisconnect(t *testing.T) {
+	c := &client{version: packet.Version5}
+	disconnect := c.errorDisconnect(xerror.ErrProtocol)
+	assert.Equal(t, code.ProtocolError, disconnect.Code)
+	assert.Nil(t, disconnect.Properties)
+
+	err := xerror.NewError(code.RetainNotSupported)
+	err.ReasonString = []byte("retain")
+	disconnect = c.errorDisconnect(err)
+	assert.Equal(t, code.RetainNotSupported, disconnect.Code)
+	assert.Equal(t, []byte("retain"), disconnect.Properties.ReasonString)
+}
+
+func TestClient_checkClientId(t *testing.T) {
+	mqtt := config.DefaultMqtt
+	c := &client{server: &server{config: &mqtt}}
+	empty5 := &packet.Connect{Version: packet.Version5}
+	empty3 := &packet.Connect{Version: packet.Version311, ConnectFlags: packet.ConnectFlags{CleanSession: true}}
+	assert.Equal(t, code.Success, c.checkClientId(empty5))
+	assert.Equal(t, code.Success, c.checkClientId(empty3))
+
+	mqtt.AllowZeroLenClientId = false
+	assert.Equal(t, code.ClientIdentifierNotValid, c.checkClientId(empty5))
+	assert.Equal(t, code.ClientIdentifierNotValid, c.checkClientId(empty3))
+	assert.Equal(t, code.Success, c.checkClientId(&packet.Connect{Version: packet.Version5, ClientId: []byte("a")}))
+}
+
+// newTestClient returns an online v5 client whose written packets are buffered in the out channel.
+func newTestClient(t *testing.T, clientId string) *client {
+	mqtt := config.DefaultMqtt
+	s := &server{
+		config:            &mqtt,
+		queueStores:       make(map[string]queue.Queue),
+		subscriptionStore: memory.New(),
+		sharedDispatcher:  newSharedDispatcher(mqtt.SharedSubStrategy),
+		tracer:            otel.GetTracerProvider().Tracer(xtrace.Name),
+		log:               xlog.LoggerModule("server"),
+	}
+	return &client{
+		server:         s,
+		clientId:       clientId,
+		version:        packet.Version5,
+		out:            make(chan packet.Packet, 8),
+		closed:         make(chan struct{}),
+		log:            xlog.LoggerModule("client"),
+		unackStore:     unackMem.New(unackMem.Options{ClientID: clientId}),
+		queueStore:     newTestQueue(t, clientId),
+		inboundAliases: newInboundTopicAliases(0),
+	}
+}
+
+// newTestQueue returns an initialized memory queue.
+func newTestQueue(t *testing.T, clientId string) queue.Queue {
+	q, err := mem.New(mem.Options{ClientID: clientId, MaxQueuedMsg: 10})
+	assert.NoError(t, err)
+	assert.NoError(t, q.Init(context.Background(), &queue.InitOptions{
+		CleanStart:     true,
+		Version:        packet.Version5,
+		ReadBytesLimit: 1024,
+		Notifier:       newQueueNotifier(clientId),
+	}))
+	return q
+}
+
+func TestClient_handlePublish_qos2(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, "pub")
+	subQueue := newTestQueue(t, "sub")
+	c.server.queueStores["sub"] = subQueue
+	_, err := c.server.subscriptionStore.Subscribe(ctx, "sub", &sub.Subscription{TopicFilter: "a", QoS: packet.QoS2})
+	assert.NoError(t, err)
+
+	publish := &packet.Publish{Version: packet.Version5, QoS: packet.QoS2, PacketId: 1, TopicName: []byte("a"), Payload: []byte("a")}
+	assert.Nil(t, c.handlePublish(publish))
+	// the client resends the PUBLISH before receiving the PUBREC.
+	dup := *publish
+	dup.Dup = true
+	assert.Nil(t, c.handlePublish(&dup))
+	for i := 0; i < 2; i++ {
+		pubrec := (<-c.out).(*packet.Pubrec)
+		assert.Equal(t, packet.Id(1), pubrec.PacketId)
+		assert.Equal(t, code.Success, pubrec.Code)
+	}
+
+	// the message is delivered only once. [MQTT-4.3.3-10]
+	_, err = subQueue.ReadInflight(ctx, 10)
+	assert.NoError(t, err)
+	elems, err := subQueue.Read(ctx, []packet.Id{1, 2})
+	assert.NoError(t, err)
+	assert.Len(t, elems, 1)
+
+	c.handlePubrel(&packet.Pubrel{Version: packet.Version5, PacketId: 1})
+	assert.Equal(t, code.Success, (<-c.out).(*packet.Pubcomp).Code)
+}
+
+func TestClient_handlePubrel(t *testing.T) {
+	c := newTestClient(t, "a")
+	c.handlePubrel(&packet.Pubrel{Version: packet.Version5, PacketId: 1})
+	pubcomp := (<-c.out).(*packet.Pubcomp)
+	assert.Equal(t, packet.Id(1), pubcomp.PacketId)
+	assert.Equal(t, code.PacketIDNotFound, pubcomp.Code)
+
+	c.version = packet.Version311
+	c.handlePubrel(&packet.Pubrel{Version: packet.Version311, PacketId: 1})
+	assert.Equal(t, code.Success, (<-c.out).(*packet.Pubcomp).Code)
+}
+
+// sendInflight puts an outbound message of the client inflight and returns its packet id.
+func sendInflight(t *testing.T, c *client, qos packet.QoS) packet.Id {
+	ctx := context.Background()
+	assert.NoError(t, c.queueStore.Add(ctx, &queue.Element{
+		At:      time.Now(),
+		Message: &queue.Publish{Message: &message.Message{Topic: "a", QoS: qos, Payload: []byte("a")}},
+	}))
+	_, err := c.queueStore.ReadInflight(ctx, 10)
+	assert.NoError(t, err)
+	ids := c.limit.pollPacketIds(1)
+	elems, err := c.queueStore.Read(ctx, ids)
+	assert.NoError(t, err)
+	assert.Len(t, elems, 1)
+	return ids[0]
+}
+
+// inflightElems returns the inflight messages left in the queue of the client.
+func inflightElems(t *testing.T, c *client) []*queue.Element {
+	ctx := context.Background()
+	assert.NoError(t, c.queueStore.Init(ctx, &queue.InitOptions{
+		Version:        packet.Version5,
+		ReadBytesLimit: 1024,
+		Notifier:       newQueueNotifier(c.clientId),
+	}))
+	elems, err := c.queueStore.ReadInflight(ctx, 10)
+	assert.NoError(t, err)
+	return elems
+}
+
+func TestClient_handlePuback(t *testing.T) {
+	c := newTestClient(t, "a")
+	c.newPacketIdLimiter(2)
+	id := sendInflight(t, c, packet.QoS1)
+	assert.Equal(t, uint16(1), c.limit.usedCount())
+
+	c.handlePuback(&packet.Puback{Version: packet.Version5, PacketId: id})
+	assert.Empty(t, inflightElems(t, c))
+	assert.Zero(t, c.limit.usedCount())
+}
+
+func TestClient_handlePubrec(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		c := newTestClient(t, "a")
+		c.newPacketIdLimiter(2)
+		id := sendInflight(t, c, packet.QoS2)
+
+		c.handlePubrec(&packet.Pubrec{Version: packet.Version5, PacketId: id})
+		assert.Equal(t, id, (<-c.out).(*packet.Pubrel).PacketId)
+		// the message is replaced by the PUBREL and keeps the packet id until PUBCOMP.
+		elems := inflightElems(t, c)
+		if assert.Len(t, elems, 1) {
+			assert.Equal(t, &queue.Pubrel{PacketID: id}, elems[0].Message)
+		}
+		assert.Equal(t, uint16(1), c.limit.usedCount())
+
+		c.handlePubcomp(&packet.Pubcomp{Version: packet.Version5, PacketId: id})
+		assert.Empty(t, inflightElems(t, c))
+		assert.Zero(t, c.limit.usedCount())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		c := newTestClient(t, "a")
+		c.newPacketIdLimiter(2)
+		id := sendInflight(t, c, packet.QoS2)
+
+		// the flow ends without PUBREL if the Reason Code is 0x80 or greater. [MQTT-4.3.3-4]
+		c.handlePubrec(&packet.Pubrec{Version: packet.Version5, PacketId: id, Code: code.NotAuthorized})
+		assert.Empty(t, c.out)
+		assert.Empty(t, inflightElems(t, c))
+		assert.Zero(t, c.limit.usedCount())
+	})
+}
+
+// newTestServer returns the server with memory persistence which serves on a random local port.
+func newTestServer(t *testing.T, opts ...Option) *server {
+	store := config.StoreType{Type: persistence.Memory}
+	s := NewServer(append([]Option{
+		WithTcpListen("127.0.0.1:0"),
+		WithPersistence(&config.Persistence{Session: store, Subscription: store, Queue: store, Retained: store, Unack: store}),
+	}, opts...)...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ServeTCP()
+	}()
+	t.Cleanup(func() {
+		_ = s.tcpListener.Close()
+		<-done
+	})
+	return s
+}
+
+// testConn is the network connection of a client connected to the test server.
+type testConn struct {
+	t       *testing.T
+	conn    net.Conn
+	version packet.Version
+	reader  *bufio.Reader
+}
+
+// newTestConnect returns the CONNECT packet of the client.
+func newTestConnect(version packet.Version, clientId string, cleanSession bool) *packet.Connect {
+	return &packet.Connect{
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		Version:       version,
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(version),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: cleanSession},
+		KeepAlive:     60,
+		ClientId:      []byte(clientId),
+	}
+}
+
+// dialTestServer connects to the test server and returns the connection and the CONNACK packet.
+func dialTestServer(t *testing.T, s *server, connect *packet.Connect) (*testConn, *packet.Connack) {
+	conn, err := net.Dial("tcp", s.tcpListener.Addr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	c := &testConn{t: t, conn: conn, version: connect.Version, reader: bufio.NewReader(conn)}
+	c.write(connect)
+	connack, ok := c.read().(*packet.Connack)
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	return c, connack
+}
+
+func (c *testConn) write(p packet.Packet) {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	assert.NoError(c.t, packet.NewWriter(c.conn).WritePacketAndFlush(p))
+}
+
+// read returns the next packet sent by the server, or nil if the connection is closed.
+func (c *testConn) read() packet.Packet {
+	p, err := c.readTimeout(3 * time.Second)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			c.t.Fatal("read timeout")
+		}
+		return nil
+	}
+	return p
+}
+
+// readTimeout reads the next packet sent by the server in timeout.
+func (c *testConn) readTimeout(timeout time.Duration) (packet.Packet, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	b, err := c.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	fh := &packet.FixedHeader{PacketType: b >> 4, Flags: b & 15}
+	if fh.RemainLength, err = packet.DecodeRemainLength(c.reader); err != nil {
+		return nil, err
+	}
+	return packet.NewPacket(fh, c.version, c.reader)
+}
+
+// assertNoPacket asserts that the server sends nothing in a short time.
+func (c *testConn) assertNoPacket() {
+	p, err := c.readTimeout(100 * time.Millisecond)
+	assert.Nil(c.t, p)
+	if ne, ok := err.(net.Error); assert.True(c.t, ok) {
+		assert.True(c.t, ne.Timeout())
+	}
+}
+
+// subscribe subscribes the topic filters and waits for the SUBACK packet.
+func (c *testConn) subscribe(qos packet.QoS, topicFilters ...string) {
+	subscribe := &packet.Subscribe{Version: c.version, PacketId: 1}
+	for _, topicFilter := range topicFilters {
+		subscribe.Topics = append(subscribe.Topics, &packet.Topic{SubOptions: packet.SubOptions{QoS: qos}, Name: topicFilter})
+	}
+	c.write(subscribe)
+	assert.IsType(c.t, &packet.Suback{}, c.read())
+}
+
+// publish publishes the QoS 0 message.
+func (c *testConn) publish(topic, payload string) {
+	c.write(&packet.Publish{Version: c.version, TopicName: []byte(topic), Payload: []byte(payload)})
+}
+
+// assertPublish asserts that the next packet is the PUBLISH packet of the topic and returns it.
+func (c *testConn) assertPublish(topic, payload string) *packet.Publish {
+	publish, ok := c.read().(*packet.Publish)
+	if !assert.True(c.t, ok, "publish") {
+		c.t.FailNow()
+	}
+	assert.Equal(c.t, topic, string(publish.TopicName))
+	assert.Equal(c.t, payload, string(publish.Payload))
+	return publish
+}
+
+func TestClient_takeover(t *testing.T) {
+	for _, version := range []packet.Version{packet.Version311, packet.Version5} {
+		t.Run(version.String(), func(t *testing.T) {
+			s := newTestServer(t)
+			watcher, _ := dialTestServer(t, s, newTestConnect(version, "watcher", true))
+			watcher.subscribe(packet.QoS0, "will")
+
+			connect := newTestConnect(version, "a", false)
+			connect.WillFlag = true
+			connect.WillTopic = []byte("will")
+			connect.WillMessage = []byte("a")
+			old, connack := dialTestServer(t, s, connect)
+			assert.False(t, connack.SessionPresent)
+			old.subscribe(packet.QoS0, "a/b")
+
+			c, connack := dialTestServer(t, s, newTestConnect(version, "a", false))
+			assert.True(t, connack.SessionPresent)
+			if packet.IsVersion5(version) {
+				// [MQTT-3.1.4-3]
+				if disconnect, ok := old.read().(*packet.Disconnect); assert.True(t, ok) {
+					assert.Equal(t, code.SessionTakenOver, disconnect.Code)
+				}
+			}
+			// the old connection is closed.
+			assert.Nil(t, old.read())
+
+			// the subscription of the session is kept by the new connection.
+			watcher.publish("a/b", "b")
+			c.assertPublish("a/b", "b")
+			// the session does not end, so the will message of the old connection is not published.
+			watcher.assertNoPacket()
+		})
+	}
+}
+
+func TestClient_connectAuthentication_fail(t *testing.T) {
+	s := newTestServer(t)
+	s.newQueueStore = func(config *config.StoreType, opts *queue.StoreOptions) (queue.Queue, error) {
+		return nil, errors.New("queue store")
+	}
+	for _, version := range []packet.Version{packet.Version311, packet.Version5} {
+		c := &client{
+			server:            s,
+			out:               make(chan packet.Packet, 8),
+			closed:            make(chan struct{}),
+			connected:         make(chan struct{}),
+			log:               xlog.LoggerModule("client"),
+			subscriptionStore: s.subscriptionStore,
+		}
+		assert.False(t, c.connectAuthentication(context.Background(), newTestConnect(version, "a", true), nil))
+		// the failed connection must not be the online client of the client id.
+		assert.NotContains(t, s.clients, "a")
+		if connack, ok := (<-c.out).(*packet.Connack); assert.True(t, ok) {
+			// 0x80 for v5 client and 0x03 (Server unavailable) for v3 client.
+			cd, _ := packet.ConnackCode(version, code.UnspecifiedError)
+			assert.Equal(t, cd, connack.Code)
+		}
+	}
+}
+
+// closeTestConn closes the connection and waits for the server to handle the closing.
+func closeTestConn(t *testing.T, s *server, c *testConn, clientId string) {
+	assert.NoError(t, c.conn.Close())
+	assert.Eventually(t, func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, ok := s.clients[clientId]
+		return !ok
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestClient_connectAuthentication_session(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	pub, _ := dialTestServer(t, s, newTestConnect(packet.Version311, "pub", true))
+	pub.subscribe(packet.QoS2, "qos2")
+	publish := func(payload string) {
+		pub.write(&packet.Publish{Version: packet.Version311, QoS: packet.QoS1, PacketId: 1, TopicName: []byte("a/b"), Payload: []byte(payload)})
+		assert.IsType(t, &packet.Puback{}, pub.read())
+	}
+
+	// [MQTT-3.2.2-3]
+	c, connack := dialTestServer(t, s, newTestConnect(packet.Version311, "a", false))
+	assert.False(t, connack.SessionPresent)
+	c.subscribe(packet.QoS1, "a/b")
+	publish("inflight")
+	// the message is not acknowledged.
+	c.assertPublish("a/b", "inflight")
+	// the QoS 2 message is not released.
+	c.write(&packet.Publish{Version: packet.Version311, QoS: packet.QoS2, PacketId: 1, TopicName: []byte("qos2"), Payload: []byte("a")})
+	assert.IsType(t, &packet.Pubrec{}, c.read())
+	pub.assertPublish("qos2", "a")
+	closeTestConn(t, s, c, "a")
+	publish("queued")
+
+	t.Run("resume", func(t *testing.T) {
+		c, connack := dialTestServer(t, s, newTestConnect(packet.Version311, "a", false))
+		// [MQTT-3.2.2-2]
+		assert.True(t, connack.SessionPresent)
+		// the inflight message is resent before the queued message. [MQTT-4.4.0-1]
+		assert.True(t, c.assertPublish("a/b", "inflight").Dup)
+		c.assertPublish("a/b", "queued")
+		// the subscription is resumed. [MQTT-3.1.2-4]
+		publish("online")
+		c.assertPublish("a/b", "online")
+		// the resent QoS 2 message is not delivered again. [MQTT-4.3.3-10]
+		c.write(&packet.Publish{Version: packet.Version311, QoS: packet.QoS2, Dup: true, PacketId: 1, TopicName: []byte("qos2"), Payload: []byte("a")})
+		assert.IsType(t, &packet.Pubrec{}, c.read())
+		pub.assertNoPacket()
+		closeTestConn(t, s, c, "a")
+	})
+
+	t.Run("clean start", func(t *testing.T) {
+		c, connack := dialTestServer(t, s, newTestConnect(packet.Version311, "a", true))
+		// [MQTT-3.2.2-1]
+		assert.False(t, connack.SessionPresent)
+		// the subscriptions, the queued messages and the inflight messages are discarded. [MQTT-3.1.2-6]
+		assert.Empty(t, subscription.GetClientSubscriptions(ctx, s.subscriptionStore, "a", subscription.TypeAll))
+		c.assertNoPacket()
+		publish("clean")
+		c.assertNoPacket()
+		// the packet id of the unreleased QoS 2 message is available again.
+		c.write(&packet.Publish{Version: packet.Version311, QoS: packet.QoS2, PacketId: 1, TopicName: []byte("qos2"), Payload: []byte("b")})
+		assert.IsType(t, &packet.Pubrec{}, c.read())
+		pub.assertPublish("qos2", "b")
+	})
+}
+
+func TestClient_handleDisconnect(t *testing.T) {
+	ctx := context.Background()
+	newClient := func(t *testing.T, sessionExpiry uint32) *client {
+		c := newTestClient(t, "a")
+		store, err := sessionMemory.New()(&config.StoreType{})
+		assert.NoError(t, err)
+		c.server.sessionStore = store
+		c.session = &session.Session{ClientId: "a", Will: &message.Message{Topic: "will"}, ConnectedAt: time.Now(), ExpiryInterval: sessionExpiry}
+		assert.NoError(t, store.Set(ctx, c.session))
+		c.opt = &ClientOption{ClientId: "a", SessionExpiry: sessionExpiry}
+		return c
+	}
+	storedExpiry := func(t *testing.T, c *client) uint32 {
+		sess, err := c.server.sessionStore.Get(ctx, "a")
+		assert.NoError(t, err)
+		return sess.ExpiryInterval
+	}
+
+	t.Run("normal disconnection", func(t *testing.T) {
+		c := newClient(t, 10)
+		assert.Nil(t, c.handleDisconnect(&packet.Disconnect{Version: packet.Version5, Code: code.NormalDisconnection}))
+		// [MQTT-3.1.2-10]
+		assert.Nil(t, c.willMessage())
+	})
+	t.Run("disconnect with will message", func(t *testing.T) {
+		c := newClient(t, 10)
+		assert.Nil(t, c.handleDisconnect(&packet.Disconnect{Version: packet.Version5, Code: code.DisconnectWithWillMessage}))
+		assert.NotNil(t, c.willMessage())
+	})
+	t.Run("v3", func(t *testing.T) {
+		c := newClient(t, 10)
+		c.version = packet.Version311
+		assert.Nil(t, c.handleDisconnect(&packet.Disconnect{Version: packet.Version311}))
+		assert.Nil(t, c.willMessage())
+	})
+	t.Run("session expiry", func(t *testing.T) {
+		c := newClient(t, 10)
+		expiry := uint32(20)
+		assert.Nil(t, c.handleDisconnect(&packet.Disconnect{Version: packet.Version5, Properties: &packet.Properties{SessionExpiryInterval: &expiry}}))
+		assert.EqualValues(t, 20, c.opt.SessionExpiry)
+		assert.EqualValues(t, 20, storedExpiry(t, c))
+
+		// the Session Expiry Interval is limited by config.Mqtt.SessionExpiry.
+		expiry = math.MaxUint32
+		assert.Nil(t, c.handleDisconnect(&packet.Disconnect{Version: packet.Version5, Properties: &packet.Properties{SessionExpiryInterval: &expiry}}))
+		assert.Equal(t, c.server.maxSessionExpiry(), c.opt.SessionExpiry)
+		assert.Equal(t, c.server.maxSessionExpiry(), storedExpiry(t, c))
+	})
+	t.Run("session expiry after zero", func(t *testing.T) {
+		c := newClient(t, 0)
+		expiry := uint32(10)
+		err := c.handleDisconnect(&packet.Disconnect{Version: packet.Version5, Properties: &packet.Properties{SessionExpiryInterval: &expiry}})
+		// [MQTT-3.14.2-2]
+		if assert.Equal(t, xerror.ErrProtocol, err) {
+			assert.Equal(t, code.ProtocolError, c.errorDisconnect(err).Code)
+		}
+		assert.Zero(t, c.opt.SessionExpiry)
+		assert.Zero(t, storedExpiry(t, c))
+	})
+}
+
+func TestClient_handleDisconnect_protocolError(t *testing.T) {
+	s := newTestServer(t)
+	c, _ := dialTestServer(t, s, newTestConnect(packet.Version5, "a", true))
+	expiry := uint32(10)
+	c.write(&packet.Disconnect{Version: packet.Version5, Properties: &packet.Properties{SessionExpiryInterval: &expiry}})
+	// the server sends DISCONNECT with Reason Code 0x82 (Protocol Error) and closes the connection. [MQTT-3.14.2-2]
+	if disconnect, ok := c.read().(*packet.Disconnect); assert.True(t, ok) {
+		assert.Equal(t, code.ProtocolError, disconnect.Code)
+	}
+	assert.Nil(t, c.read())
+}
@@ -17,10 +17,208 @@
 package server
 
 import (
+	"context"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/packet"
 	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"go.uber.org/zap"
+	"strings"
+	"time"
 )
 
 // Deliverer 表示具备投递信息功能的一类对象
 type Deliverer interface {
 	Deliver(message message.Message) error
 }
+
+// deliverMessage routes the message to the queue of every client that has a matching subscription.
+// The message is delivered to only one subscriber of each matching shared subscription group.
+// It returns whether there is any matching subscription.
+func (s *server) deliverMessage(ctx context.Context, publisher string, msg *message.Message) (matched bool) {
+	now := time.Now()
+	if s.config.SharedSubAvailable {
+		matched = s.deliverSharedMessage(ctx, publisher, msg, now)
+	}
+	clientSubscriptions := subscription.GetTopicMatched(ctx, s.subscriptionStore, msg.Topic, subscription.TypeSYS|subscription.TypeNonShared)
+	overlap := strings.EqualFold(s.config.DeliveryMode, config.DeliveryModeOverlap)
+	for clientId, subscriptions := range clientSubscriptions {
+		matched = true
+		if clientId == publisher {
+			subscriptions = withoutNoLocal(subscriptions)
+			if len(subscriptions) == 0 {
+				continue
+			}
+		}
+		if overlap {
+			// deliver one message for each matching subscription.
+			for _, subscription := range subscriptions {
+				s.addMessageToQueue(ctx, clientId, subscriptionMessage(msg, subscription), subscription.QoS, now)
+			}
+			continue
+		}
+		// deliver only one message respecting the maximum QoS of all the matching subscriptions. [MQTT-3.3.4-2]
+		m, qos := mergeSubscriptions(msg, subscriptions)
+		s.addMessageToQueue(ctx, clientId, m, qos, now)
+	}
+	return matched
+}
+
+// withoutNoLocal removes the subscriptions with the No Local option,
+// the message must not be forwarded to the publisher through these subscriptions. [MQTT-3.8.3-3]
+func withoutNoLocal(subscriptions []*sub.Subscription) []*sub.Subscription {
+	rs := make([]*sub.Subscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		if !subscription.NoLocal {
+			rs = append(rs, subscription)
+		}
+	}
+	return rs
+}
+
+// subscriptionMessage returns a copy of the message which is delivered for the subscription.
+func subscriptionMessage(msg *message.Message, subscription *sub.Subscription) *message.Message {
+	m := msg.Copy()
+	// [MQTT-3.3.1-12] [MQTT-3.3.1-13]
+	m.Retained = msg.Retained && subscription.RetainAsPublished
+	m.SubscriptionIdentifier = nil
+	if subscription.ID != 0 {
+		m.SubscriptionIdentifier = []uint32{subscription.ID}
+	}
+	return m
+}
+
+// mergeSubscriptions returns a copy of the message which is delivered for all the overlapping subscriptions of a client
+// and the maximum QoS of the subscriptions.
+// The Subscription Identifiers of all the subscriptions are included. [MQTT-3.3.4-3]
+func mergeSubscriptions(msg *message.Message, subscriptions []*sub.Subscription) (*message.Message, packet.QoS) {
+	m := msg.Copy()
+	m.Retained = false
+	m.SubscriptionIdentifier = nil
+	var qos packet.QoS
+	for _, subscription := range subscriptions {
+		if subscription.QoS > qos {
+			qos = subscription.QoS
+		}
+		if msg.Retained && subscription.RetainAsPublished {
+			m.Retained = true
+		}
+		if subscription.ID != 0 {
+			m.SubscriptionIdentifier = append(m.SubscriptionIdentifier, subscription.ID)
+		}
+	}
+	return m, qos
+}
+
+// retainMessage stores the retained message, or removes the retained message of the topic if the payload is empty.
+// [MQTT-3.3.1-5] [MQTT-3.3.1-10] [MQTT-3.3.1-11]
+func (s *server) retainMessage(ctx context.Context, msg *message.Message) {
+	var err error
+	if len(msg.Payload) == 0 {
+		err = s.retainedStore.Remove(ctx, msg.Topic)
+	} else {
+		retained := msg.Copy()
+		// the retained message expires after the Message Expiry Interval, which is limited to config.Mqtt.MessageExpiry.
+		if max := uint32(s.config.MessageExpiry / time.Second); max != 0 && retained.MessageExpiry > max {
+			retained.MessageExpiry = max
+		}
+		err = s.retainedStore.AddOrReplace(ctx, retained)
+	}
+	if err != nil {
+		s.log.WithContext(ctx).Error("retain message", zap.String("topic", msg.Topic), zap.Error(err))
+	}
+}
+
+// deliverRetainedMessages sends the retained messages which match the new subscription to the client.
+// The Retain Handling option decides whether the retained messages are sent. [MQTT-3.3.1-9] [MQTT-3.8.4-4]
+func (s *server) deliverRetainedMessages(ctx context.Context, clientId string, subscription *sub.Subscription, alreadyExisted bool) {
+	// retained messages are not sent for shared subscriptions
+	if subscription.ShareName != "" {
+		return
+	}
+	switch subscription.RetainHandling {
+	case packet.RetainHandlingSend:
+	case packet.RetainHandlingSendIfNotExist:
+		if alreadyExisted {
+			return
+		}
+	default:
+		return
+	}
+
+	messages, err := s.retainedStore.GetMatchedMessages(ctx, subscription.TopicFilter)
+	if err != nil {
+		s.log.WithContext(ctx).Error("get retained messages", zap.String("topicFilter", subscription.TopicFilter), zap.Error(err))
+		return
+	}
+	now := time.Now()
+	// the expired messages are not returned by the store,
+	// and the Message Expiry Interval of the messages is the remaining lifetime. [MQTT-3.3.2-6]
+	for _, msg := range messages {
+		// retained messages sent when the subscription is established have the RETAIN flag set to 1.
+		msg.Retained = true
+		s.addMessageToQueue(ctx, clientId, msg, subscription.QoS, now)
+	}
+}
+
+// validPayloadFormat returns false if the payload is indicated as UTF-8 but is not valid UTF-8,
+// and config.Mqtt.PayloadFormatCheck enforces the validation. [MQTT-3.3.2-4]
+func (s *server) validPayloadFormat(ctx context.Context, clientId string, format packet.PayloadFormat, payload []byte) bool {
+	mode := s.config.PayloadFormatCheck
+	if format != packet.PayloadFormatString || strings.EqualFold(mode, config.PayloadFormatCheckOff) {
+		return true
+	}
+	if packet.ValidUTF8(payload) {
+		return true
+	}
+	s.log.WithContext(ctx).Warn("invalid payload format", zap.String("clientId", clientId))
+	return strings.EqualFold(mode, config.PayloadFormatCheckLog)
+}
+
+// messageExpiry returns the time when the message expires in the queue, the zero time means the message never expires.
+// The Message Expiry Interval of the message is limited to config.Mqtt.MessageExpiry,
+// which is also the lifetime of the messages without Message Expiry Interval.
+func (s *server) messageExpiry(msg *message.Message, now time.Time) time.Time {
+	max := uint32(s.config.MessageExpiry / time.Second)
+	if max != 0 && msg.MessageExpiry > max {
+		msg.MessageExpiry = max
+	}
+	lifetime := msg.MessageExpiry
+	if lifetime == 0 {
+		lifetime = max
+	}
+	if lifetime == 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(lifetime) * time.Second)
+}
+
+// addMessageToQueue adds the message to the queue of the client.
+// The QoS of the message is downgraded to the granted QoS of the subscription.
+// It returns whether the message has been added to the queue.
+func (s *server) addMessageToQueue(ctx context.Context, clientId string, msg *message.Message, qos packet.QoS, now time.Time) bool {
+	s.mu.RLock()
+	queueStore, ok := s.queueStores[clientId]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	msg.Dup = false
+	msg.PacketId = 0
+	if msg.QoS > qos {
+		msg.QoS = qos
+	}
+	err := queueStore.Add(ctx, &queue.Element{
+		At:      now,
+		Expiry:  s.messageExpiry(msg, now),
+		Message: &queue.Publish{Message: msg},
+	})
+	if err != nil {
+		s.log.WithContext(ctx).Error("add message to queue", zap.String("clientId", clientId), zap.Error(err))
+		return false
+	}
+	return true
+}
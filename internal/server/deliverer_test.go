@@ -0,0 +1,220 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	retainedMemory "github.com/yunqi/lighthouse/internal/persistence/retained/memory"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"testing"
+	"time"
+)
+
+func TestServer_deliverMessage(t *testing.T) {
+	ctx := context.Background()
+	deliver := func(deliveryMode string) []*message.Message {
+		mqtt := config.DefaultMqtt
+		mqtt.DeliveryMode = deliveryMode
+		s := &server{
+			config:            &mqtt,
+			queueStores:       make(map[string]queue.Queue),
+			subscriptionStore: memory.New(),
+			sharedDispatcher:  newSharedDispatcher(mqtt.SharedSubStrategy),
+			log:               xlog.LoggerModule("server"),
+		}
+		queueStore, err := mem.New(mem.Options{MaxQueuedMsg: 10})
+		assert.NoError(t, err)
+		assert.NoError(t, queueStore.Init(ctx, &queue.InitOptions{
+			CleanStart:     true,
+			Version:        packet.Version5,
+			ReadBytesLimit: 1024,
+			Notifier:       newQueueNotifier("a"),
+		}))
+		s.queueStores["a"] = queueStore
+		_, err = s.subscriptionStore.Subscribe(ctx, "a",
+			&sub.Subscription{TopicFilter: "a/#", QoS: packet.QoS1, ID: 1},
+			&sub.Subscription{TopicFilter: "a/+", QoS: packet.QoS2, ID: 2, RetainAsPublished: true},
+		)
+		assert.NoError(t, err)
+
+		assert.True(t, s.deliverMessage(ctx, "b", &message.Message{Topic: "a/b", QoS: packet.QoS2, Retained: true, Payload: []byte("a")}))
+		_, err = queueStore.ReadInflight(ctx, 10)
+		assert.NoError(t, err)
+		elems, err := queueStore.Read(ctx, []packet.Id{1, 2, 3})
+		assert.NoError(t, err)
+		var rs []*message.Message
+		for _, elem := range elems {
+			rs = append(rs, elem.Message.(*queue.Publish).Message)
+		}
+		return rs
+	}
+
+	t.Run("onlyonce", func(t *testing.T) {
+		rs := deliver(config.DeliveryModeOnlyOnce)
+		if assert.Len(t, rs, 1) {
+			assert.Equal(t, packet.QoS2, rs[0].QoS)
+			assert.True(t, rs[0].Retained)
+			assert.ElementsMatch(t, []uint32{1, 2}, rs[0].SubscriptionIdentifier)
+		}
+	})
+
+	t.Run("overlap", func(t *testing.T) {
+		rs := deliver(config.DeliveryModeOverlap)
+		if assert.Len(t, rs, 2) {
+			for _, m := range rs {
+				if assert.Len(t, m.SubscriptionIdentifier, 1) {
+					id := m.SubscriptionIdentifier[0]
+					assert.Equal(t, packet.QoS(id), m.QoS)
+					assert.Equal(t, id == 2, m.Retained)
+				}
+			}
+		}
+	})
+}
+
+func TestServer_deliverMessage_route(t *testing.T) {
+	ctx := context.Background()
+	mqtt := config.DefaultMqtt
+	s := &server{
+		config:            &mqtt,
+		queueStores:       make(map[string]queue.Queue),
+		subscriptionStore: memory.New(),
+		sharedDispatcher:  newSharedDispatcher(mqtt.SharedSubStrategy),
+		log:               xlog.LoggerModule("server"),
+	}
+	queueStore, err := mem.New(mem.Options{MaxQueuedMsg: 10})
+	assert.NoError(t, err)
+	assert.NoError(t, queueStore.Init(ctx, &queue.InitOptions{
+		CleanStart:     true,
+		Version:        packet.Version311,
+		ReadBytesLimit: 1024,
+		Notifier:       newQueueNotifier("a"),
+	}))
+	s.queueStores["a"] = queueStore
+	_, err = s.subscriptionStore.Subscribe(ctx, "a", &sub.Subscription{TopicFilter: "a/#", QoS: packet.QoS1})
+	assert.NoError(t, err)
+	// the subscriber without a queue is skipped.
+	_, err = s.subscriptionStore.Subscribe(ctx, "b", &sub.Subscription{TopicFilter: "a/b", QoS: packet.QoS1})
+	assert.NoError(t, err)
+
+	assert.False(t, s.deliverMessage(ctx, "c", &message.Message{Topic: "b/a", QoS: packet.QoS1}))
+	msg := &message.Message{Topic: "a/b", QoS: packet.QoS2, Retained: true, PacketId: 10, Payload: []byte("a")}
+	assert.True(t, s.deliverMessage(ctx, "c", msg))
+
+	_, err = queueStore.ReadInflight(ctx, 10)
+	assert.NoError(t, err)
+	elems, err := queueStore.Read(ctx, []packet.Id{1, 2})
+	assert.NoError(t, err)
+	if assert.Len(t, elems, 1) {
+		m := elems[0].Message.(*queue.Publish).Message
+		assert.Equal(t, "a/b", m.Topic)
+		assert.Equal(t, []byte("a"), m.Payload)
+		// the QoS is downgraded to the subscription QoS and the retain flag is cleared.
+		assert.Equal(t, packet.QoS1, m.QoS)
+		assert.False(t, m.Retained)
+	}
+	// the delivered message is a copy.
+	assert.Equal(t, packet.QoS2, msg.QoS)
+	assert.True(t, msg.Retained)
+}
+
+func TestWithoutNoLocal(t *testing.T) {
+	subscriptions := []*sub.Subscription{
+		{TopicFilter: "a/#", NoLocal: true},
+		{TopicFilter: "a/+"},
+	}
+	rs := withoutNoLocal(subscriptions)
+	if assert.Len(t, rs, 1) {
+		assert.Equal(t, "a/+", rs[0].TopicFilter)
+	}
+	assert.Empty(t, withoutNoLocal(subscriptions[:1]))
+}
+
+func TestServer_messageExpiry(t *testing.T) {
+	mqtt := config.DefaultMqtt
+	mqtt.MessageExpiry = time.Minute
+	s := &server{config: &mqtt}
+	now := time.Now()
+
+	msg := &message.Message{MessageExpiry: 10}
+	assert.Equal(t, now.Add(10*time.Second), s.messageExpiry(msg, now))
+	msg = &message.Message{MessageExpiry: 100}
+	assert.Equal(t, now.Add(time.Minute), s.messageExpiry(msg, now))
+	assert.Equal(t, uint32(60), msg.MessageExpiry)
+	assert.Equal(t, now.Add(time.Minute), s.messageExpiry(&message.Message{}, now))
+
+	mqtt.MessageExpiry = 0
+	assert.True(t, s.messageExpiry(&message.Message{}, now).IsZero())
+	assert.Equal(t, now.Add(100*time.Second), s.messageExpiry(&message.Message{MessageExpiry: 100}, now))
+}
+
+func TestServer_retainMessage(t *testing.T) {
+	ctx := context.Background()
+	mqtt := config.DefaultMqtt
+	mqtt.MessageExpiry = time.Minute
+	s := &server{
+		config:        &mqtt,
+		queueStores:   make(map[string]queue.Queue),
+		retainedStore: retainedMemory.New(),
+		log:           xlog.LoggerModule("server"),
+	}
+	s.retainMessage(ctx, &message.Message{Topic: "a/b", Payload: []byte("b"), MessageExpiry: 3600})
+	s.retainMessage(ctx, &message.Message{Topic: "a/c", Payload: []byte("c")})
+
+	queueStore := newTestQueue(t, "a")
+	s.queueStores["a"] = queueStore
+	s.deliverRetainedMessages(ctx, "a", &sub.Subscription{TopicFilter: "a/#", QoS: packet.QoS1}, false)
+	_, err := queueStore.ReadInflight(ctx, 10)
+	assert.NoError(t, err)
+	elems, err := queueStore.Read(ctx, []packet.Id{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Len(t, elems, 2)
+	for _, elem := range elems {
+		msg := elem.Message.(*queue.Publish).Message
+		assert.True(t, msg.Retained)
+		if msg.Topic == "a/b" {
+			// the Message Expiry Interval is limited to config.Mqtt.MessageExpiry, and counts down while retained.
+			assert.True(t, msg.MessageExpiry > 0 && msg.MessageExpiry <= 60, msg.MessageExpiry)
+		} else {
+			assert.Zero(t, msg.MessageExpiry)
+		}
+	}
+}
+
+func TestServer_validPayloadFormat(t *testing.T) {
+	mqtt := config.DefaultMqtt
+	s := &server{config: &mqtt, log: xlog.LoggerModule("server")}
+	ctx := context.Background()
+	invalid := []byte{0xff, 0xfe}
+
+	assert.True(t, s.validPayloadFormat(ctx, "c", packet.PayloadFormatString, []byte("a")))
+	assert.True(t, s.validPayloadFormat(ctx, "c", packet.PayloadFormatBytes, invalid))
+	assert.False(t, s.validPayloadFormat(ctx, "c", packet.PayloadFormatString, invalid))
+
+	mqtt.PayloadFormatCheck = config.PayloadFormatCheckLog
+	assert.True(t, s.validPayloadFormat(ctx, "c", packet.PayloadFormatString, invalid))
+	mqtt.PayloadFormatCheck = config.PayloadFormatCheckOff
+	assert.True(t, s.validPayloadFormat(ctx, "c", packet.PayloadFormatString, invalid))
+}
@@ -59,8 +59,18 @@ func (p *packetIdLimiter) unlock() {
 	p.cond.L.Unlock()
 }
 
+// usedCount returns the number of used packet ids.
+func (p *packetIdLimiter) usedCount() uint16 {
+	p.lock()
+	defer p.unlock()
+	return p.used
+}
+
 // markUsedLocked marks the given id as used.
 func (p *packetIdLimiter) markUsedLocked(packetId packet.Id) {
+	if p.lockedPacketIdMap.Get(packetId) == 1 {
+		return
+	}
 	p.used++
 	p.lockedPacketIdMap.Set(packetId, 1)
 }
@@ -0,0 +1,51 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"go.uber.org/zap"
+)
+
+var _ queue.Notifier = (*queueNotifier)(nil)
+
+// queueNotifier implements queue.Notifier, it logs the messages dropped by the queue of a client.
+type queueNotifier struct {
+	clientId string
+	log      *xlog.Log
+}
+
+func newQueueNotifier(clientId string) *queueNotifier {
+	return &queueNotifier{
+		clientId: clientId,
+		log:      xlog.LoggerModule("queue"),
+	}
+}
+
+func (q *queueNotifier) NotifyDropped(elem *queue.Element, err error) {
+	q.log.Warn("message dropped",
+		zap.String("clientId", q.clientId),
+		zap.Uint16("packetId", elem.Id()),
+		zap.Error(err))
+}
+
+func (q *queueNotifier) NotifyInflightAdded(delta int) {
+}
+
+func (q *queueNotifier) NotifyMsgQueueAdded(delta int) {
+}
@@ -18,18 +18,29 @@ package server
 
 import (
 	"context"
-	"github.com/gorilla/websocket"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/goroutine"
+	"github.com/yunqi/lighthouse/internal/packet"
 	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/retained"
 	"github.com/yunqi/lighthouse/internal/persistence/session"
 	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
+	sess "github.com/yunqi/lighthouse/internal/session"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
 	"github.com/yunqi/lighthouse/internal/xlog"
 	"github.com/yunqi/lighthouse/internal/xtrace"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -39,21 +50,58 @@ type (
 		Run() error
 	}
 	Option func(server *Options)
+	// SubscribeAuthorizer returns whether the client is allowed to make the subscription.
+	SubscribeAuthorizer func(ctx context.Context, clientId string, subscription *sub.Subscription) bool
 
 	Options struct {
-		tcpListen       string
-		websocketListen string
-		persistence     *config.Persistence
+		tcpListen            string
+		websocketListen      string
+		websocketPath        string
+		websocketCheckOrigin func(r *http.Request) bool
+		websocketCompression bool
+		mqtt                 *config.Mqtt
+		persistence          *config.Persistence
+		subscribeAuthorizer  SubscribeAuthorizer
+		authMethods          []auth.Method
 	}
 	server struct {
 		tcpListen         string
 		websocketListen   string
 		tcpListener       net.Listener //tcp listeners
-		websocketListener *websocket.Conn
+		websocketListener net.Listener
+		websocketServer   *http.Server
+		config            *config.Mqtt
+		mu                sync.RWMutex
+		// clients stores the online clients, key by client id.
+		clients map[string]*client
+		// offlineClients stores the session expiry time of the offline clients, key by client id.
+		offlineClients map[string]time.Time
+		// queueStores stores the message queue of each client, key by client id.
+		queueStores   map[string]queue.Queue
+		newQueueStore queue.NewStore
+		queueConfig   *config.StoreType
+		// unackStores stores the unack store of each client, key by client id.
+		unackStores   map[string]unack.Store
+		newUnackStore unack.NewStore
+		unackConfig   *config.StoreType
+		// willMessages stores the delayed will messages, key by client id.
+		willMessages map[string]*willMessage
+		// removingSessions stores the sessions which are being removed from the stores, key by client id.
+		// The channel is closed after the session has been removed.
+		removingSessions  map[string]chan struct{}
 		sessionStore      session.Store
 		subscriptionStore subscription.Store
+		retainedStore     retained.Store
 		log               *xlog.Log
 		tracer            trace.Tracer
+		// sharedDispatcher chooses the subscriber of the shared subscription group.
+		sharedDispatcher *sharedDispatcher
+		// subscribeAuthorizer authorizes the subscriptions, nil means all subscriptions are allowed.
+		subscribeAuthorizer SubscribeAuthorizer
+		// authMethods stores the enhanced authentication methods, key by the method name.
+		authMethods map[string]auth.Method
+		// exit is closed when the server stops serving.
+		exit chan struct{}
 	}
 )
 
@@ -62,6 +110,12 @@ func WithTcpListen(tcpListen string) Option {
 		opts.tcpListen = tcpListen
 	}
 }
+func WithMqtt(mqtt *config.Mqtt) Option {
+	return func(opts *Options) {
+		opts.mqtt = mqtt
+	}
+}
+
 func WithPersistence(persistence *config.Persistence) Option {
 	return func(opts *Options) {
 		opts.persistence = persistence
@@ -74,6 +128,42 @@ func WithWebsocketListen(websocketListen string) Option {
 	}
 }
 
+// WithWebsocketPath sets the path of the websocket endpoint, the default path is "/mqtt".
+func WithWebsocketPath(path string) Option {
+	return func(opts *Options) {
+		opts.websocketPath = path
+	}
+}
+
+// WithWebsocketCheckOrigin sets the function which checks the Origin header of the websocket request.
+// If it is not set, the requests whose origin is not the same as the host are rejected.
+func WithWebsocketCheckOrigin(checkOrigin func(r *http.Request) bool) Option {
+	return func(opts *Options) {
+		opts.websocketCheckOrigin = checkOrigin
+	}
+}
+
+// WithWebsocketCompression sets whether to negotiate the permessage-deflate compression with the websocket client.
+func WithWebsocketCompression(enable bool) Option {
+	return func(opts *Options) {
+		opts.websocketCompression = enable
+	}
+}
+
+// WithSubscribeAuthorizer sets the authorizer of the subscriptions.
+func WithSubscribeAuthorizer(authorizer SubscribeAuthorizer) Option {
+	return func(opts *Options) {
+		opts.subscribeAuthorizer = authorizer
+	}
+}
+
+// WithAuthMethods sets the enhanced authentication methods of MQTT v5.
+func WithAuthMethods(methods ...auth.Method) Option {
+	return func(opts *Options) {
+		opts.authMethods = append(opts.authMethods, methods...)
+	}
+}
+
 func NewServer(opts ...Option) *server {
 	options := loadServerOptions(opts...)
 	s := &server{}
@@ -89,18 +179,32 @@ func loadServerOptions(opts ...Option) *Options {
 	if options.tcpListen == "" {
 		options.tcpListen = ":1883"
 	}
+	if options.mqtt == nil {
+		mqtt := config.DefaultMqtt
+		options.mqtt = &mqtt
+	}
 	return options
 }
 
 func (s *server) ServeTCP() {
 	//propagator := otel.GetTextMapPropagator()
 	s.tracer = otel.GetTracerProvider().Tracer(xtrace.Name)
+	goroutine.Go(s.sessionExpiryCheck)
+	if s.websocketServer != nil {
+		goroutine.Go(s.serveWebsocket)
+	}
 
 	defer func() {
+		close(s.exit)
 		err := s.tcpListener.Close()
 		if err != nil {
 			s.log.Error("tcpListener close", zap.Error(err))
 		}
+		if s.websocketServer != nil {
+			if err := s.websocketServer.Close(); err != nil {
+				s.log.Error("websocket server close", zap.Error(err))
+			}
+		}
 	}()
 	var tempDelay time.Duration
 
@@ -136,6 +240,20 @@ func (s *server) init(opts *Options) {
 	s.tcpListen = opts.tcpListen
 	s.websocketListen = opts.websocketListen
 	s.log = xlog.LoggerModule("server")
+	s.config = opts.mqtt
+	s.clients = make(map[string]*client)
+	s.offlineClients = make(map[string]time.Time)
+	s.exit = make(chan struct{})
+	s.queueStores = make(map[string]queue.Queue)
+	s.unackStores = make(map[string]unack.Store)
+	s.willMessages = make(map[string]*willMessage)
+	s.removingSessions = make(map[string]chan struct{})
+	s.sharedDispatcher = newSharedDispatcher(s.config.SharedSubStrategy)
+	s.subscribeAuthorizer = opts.subscribeAuthorizer
+	s.authMethods = make(map[string]auth.Method)
+	for _, method := range opts.authMethods {
+		s.authMethods[method.Name()] = method
+	}
 
 	// session store
 	sessionStore, ok := persistence.GetSessionStore(opts.persistence.Session.Type)
@@ -163,6 +281,41 @@ func (s *server) init(opts *Options) {
 		s.log.Info("subscriptionStore store", zap.String("type", opts.persistence.Session.Type))
 	}
 
+	// retained store
+	retainedStoreFunc, ok := persistence.GetRetainedStore(opts.persistence.Retained.Type)
+	if !ok {
+		s.log.Panic("invalid retained store")
+	}
+
+	if retainedStore, err := retainedStoreFunc(&opts.persistence.Retained); err != nil {
+		s.log.Panic("retained store", zap.Error(err))
+	} else {
+		s.retainedStore = retainedStore
+		s.log.Info("retained store", zap.String("type", opts.persistence.Retained.Type))
+	}
+
+	// queue store
+	newQueueStore, ok := persistence.GetQueueStore(opts.persistence.Queue.Type)
+	if !ok {
+		s.log.Panic("invalid queue store")
+	}
+	s.newQueueStore = newQueueStore
+	s.queueConfig = &opts.persistence.Queue
+	s.log.Info("queue store", zap.String("type", opts.persistence.Queue.Type))
+
+	// unack store
+	newUnackStore, ok := persistence.GetUnackStore(opts.persistence.Unack.Type)
+	if !ok {
+		s.log.Panic("invalid unack store")
+	}
+	s.newUnackStore = newUnackStore
+	s.unackConfig = &opts.persistence.Unack
+	s.log.Info("unack store", zap.String("type", opts.persistence.Unack.Type))
+
+	if err := s.restoreSessions(context.Background()); err != nil {
+		s.log.Panic("restore sessions", zap.Error(err))
+	}
+
 	ln, err := net.Listen("tcp", s.tcpListen)
 	if err != nil {
 		s.log.Panic("start tcp error", zap.String("tcp", s.tcpListen), zap.Error(err))
@@ -170,4 +323,140 @@ func (s *server) init(opts *Options) {
 	s.log.Info("start tcp", zap.String("TCP", s.tcpListen))
 	s.tcpListener = ln
 
+	if s.websocketListen != "" {
+		ln, err = net.Listen("tcp", s.websocketListen)
+		if err != nil {
+			s.log.Panic("start websocket error", zap.String("websocket", s.websocketListen), zap.Error(err))
+		}
+		s.log.Info("start websocket", zap.String("websocket", s.websocketListen))
+		s.websocketListener = ln
+		s.websocketServer = s.newWebsocketServer(opts)
+	}
+
+}
+
+// registerClient registers the client as the online client of its client id.
+// It returns the client which is replaced, or nil if there is none.
+func (s *server) registerClient(c *client) (old *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old = s.clients[c.clientId]
+	s.clients[c.clientId] = c
+	delete(s.offlineClients, c.clientId)
+	return old
+}
+
+// newClientId returns a unique client id which is assigned to the client connecting with empty client id.
+func (s *server) newClientId() string {
+	b := make([]byte, 8)
+	for {
+		_, _ = rand.Read(b)
+		clientId := "lighthouse-" + hex.EncodeToString(b)
+		s.mu.RLock()
+		_, online := s.clients[clientId]
+		_, offline := s.offlineClients[clientId]
+		s.mu.RUnlock()
+		if !online && !offline {
+			return clientId
+		}
+	}
+}
+
+// unregisterClient removes the client if it is still the online client of its client id.
+// It returns false if the client has been taken over.
+func (s *server) unregisterClient(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c.clientId] == c {
+		delete(s.clients, c.clientId)
+		return true
+	}
+	return false
+}
+
+// DisconnectClient disconnects the online client by the server, such as kicking the client by the administrator.
+// The reason code and the properties such as Reason String and Server Reference are only sent to the v5 client.
+// It returns false if the client is not online.
+func (s *server) DisconnectClient(clientId string, cd code.Code, props *packet.Properties) bool {
+	s.mu.RLock()
+	c, ok := s.clients[clientId]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	s.log.Info("disconnect client", zap.String("clientId", clientId), zap.Uint8("code", cd))
+	c.Disconnect(&packet.Disconnect{Version: c.version, Code: cd, Properties: props})
+	return true
+}
+
+// getQueueStore returns the queue of the client, the queue will be created if it does not exist.
+func (s *server) getQueueStore(clientId string) (queue.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if queueStore, ok := s.queueStores[clientId]; ok {
+		return queueStore, nil
+	}
+	queueStore, err := s.newQueueStore(s.queueConfig, &queue.StoreOptions{
+		ClientId:        clientId,
+		MaxQueuedMsg:    s.config.MaxQueueMessages,
+		InflightExpiry:  s.config.InflightExpiry,
+		DefaultNotifier: newQueueNotifier(clientId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.queueStores[clientId] = queueStore
+	return queueStore, nil
+}
+
+// getUnackStore returns the unack store of the client, the unack store will be created if it does not exist.
+func (s *server) getUnackStore(clientId string) (unack.Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if unackStore, ok := s.unackStores[clientId]; ok {
+		return unackStore, nil
+	}
+	unackStore, err := s.newUnackStore(s.unackConfig, clientId)
+	if err != nil {
+		return nil, err
+	}
+	s.unackStores[clientId] = unackStore
+	return unackStore, nil
+}
+
+// restoreSessions loads the subscriptions and the queues of the persisted sessions,
+// so that the offline clients can receive messages after the server restarts.
+func (s *server) restoreSessions(ctx context.Context) error {
+	var clientIds []string
+	err := s.sessionStore.Iterate(ctx, func(session *sess.Session) bool {
+		clientIds = append(clientIds, session.ClientId)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.subscriptionStore.Init(ctx, clientIds); err != nil {
+		return err
+	}
+	for _, clientId := range clientIds {
+		queueStore, err := s.getQueueStore(clientId)
+		if err != nil {
+			return err
+		}
+		err = queueStore.Init(ctx, &queue.InitOptions{
+			CleanStart:     false,
+			Version:        packet.Version311,
+			ReadBytesLimit: packet.MaximumSize,
+			Notifier:       newQueueNotifier(clientId),
+		})
+		if err != nil {
+			return err
+		}
+		// the queue is opened when the client connects.
+		if err = queueStore.Close(); err != nil {
+			return err
+		}
+	}
+	s.log.Info("restore sessions", zap.Int("count", len(clientIds)))
+	return nil
 }
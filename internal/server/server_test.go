@@ -17,7 +17,24 @@
 package server
 
 import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	_ "github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	persistenceSession "github.com/yunqi/lighthouse/internal/persistence/session"
+	sessionMemory "github.com/yunqi/lighthouse/internal/persistence/session/memory"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
+	"github.com/yunqi/lighthouse/internal/session"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xlog"
 	"testing"
+	"time"
 )
 
 func TestName(t *testing.T) {
@@ -26,3 +43,199 @@ func TestName(t *testing.T) {
 	//newServer.serveTCP()
 	//select {}
 }
+
+func TestServer_getQueueStore(t *testing.T) {
+	newQueueStore, ok := persistence.GetQueueStore(persistence.Memory)
+	if !assert.True(t, ok) {
+		return
+	}
+	_, ok = persistence.GetQueueStore("unknown")
+	assert.False(t, ok)
+
+	mqtt := config.DefaultMqtt
+	s := &server{
+		config:        &mqtt,
+		queueStores:   make(map[string]queue.Queue),
+		newQueueStore: newQueueStore,
+		queueConfig:   &config.StoreType{Type: persistence.Memory},
+	}
+	a, err := s.getQueueStore("a")
+	assert.NoError(t, err)
+	// the queue is reused when the client reconnects.
+	a2, err := s.getQueueStore("a")
+	assert.NoError(t, err)
+	assert.Same(t, a, a2)
+	b, err := s.getQueueStore("b")
+	assert.NoError(t, err)
+	assert.NotSame(t, a, b)
+	assert.Len(t, s.queueStores, 2)
+}
+
+// closeQueue records whether the queue is closed.
+type closeQueue struct {
+	queue.Queue
+	closed bool
+}
+
+func (q *closeQueue) Close() error {
+	q.closed = true
+	return nil
+}
+
+func TestClient_handleConn_closeQueue(t *testing.T) {
+	q := &closeQueue{}
+	c := &client{
+		server:     &server{clients: make(map[string]*client)},
+		in:         make(chan packet.Packet),
+		out:        make(chan packet.Packet, 1),
+		closed:     make(chan struct{}),
+		log:        xlog.LoggerModule("client"),
+		queueStore: q,
+	}
+	c.newPacketIdLimiter(1)
+	close(c.in)
+	c.handleConn()
+	assert.True(t, q.closed)
+}
+
+func TestServer_registerClient(t *testing.T) {
+	s := &server{clients: make(map[string]*client), offlineClients: make(map[string]time.Time)}
+	c1 := &client{clientId: "a"}
+	c2 := &client{clientId: "a"}
+
+	assert.Nil(t, s.registerClient(c1))
+	assert.Equal(t, c1, s.registerClient(c2))
+
+	// the replaced client must not remove the new one.
+	assert.False(t, s.unregisterClient(c1))
+	assert.Equal(t, c2, s.clients["a"])
+
+	assert.True(t, s.unregisterClient(c2))
+	assert.Empty(t, s.clients)
+}
+
+func TestServer_newClientId(t *testing.T) {
+	s := &server{clients: make(map[string]*client), offlineClients: make(map[string]time.Time)}
+	a := s.newClientId()
+	s.clients[a] = &client{clientId: a}
+	b := s.newClientId()
+	assert.NotEmpty(t, a)
+	assert.NotEqual(t, a, b)
+}
+
+func TestServer_removeExpiredSessions(t *testing.T) {
+	ctx := context.Background()
+	sessionStore, err := sessionMemory.New()(&config.StoreType{})
+	assert.NoError(t, err)
+	s := &server{
+		config:            &config.DefaultMqtt,
+		clients:           make(map[string]*client),
+		offlineClients:    make(map[string]time.Time),
+		queueStores:       make(map[string]queue.Queue),
+		unackStores:       make(map[string]unack.Store),
+		willMessages:      make(map[string]*willMessage),
+		removingSessions:  make(map[string]chan struct{}),
+		sessionStore:      sessionStore,
+		subscriptionStore: memory.New(),
+		log:               xlog.LoggerModule("server"),
+	}
+	now := time.Now()
+	for _, clientId := range []string{"online", "offline", "expired", "restored"} {
+		assert.NoError(t, sessionStore.Set(ctx, &session.Session{
+			ClientId:       clientId,
+			ConnectedAt:    now.Add(-time.Hour),
+			ExpiryInterval: 60,
+		}))
+	}
+	s.clients["online"] = &client{clientId: "online"}
+	s.offlineClients["offline"] = now.Add(time.Minute)
+	s.offlineClients["expired"] = now.Add(-time.Second)
+
+	s.removeExpiredSessions(ctx, now)
+
+	for clientId, exist := range map[string]bool{"online": true, "offline": true, "expired": false, "restored": false} {
+		sess, err := sessionStore.Get(ctx, clientId)
+		assert.NoError(t, err)
+		assert.Equal(t, exist, sess != nil, clientId)
+	}
+	assert.NotContains(t, s.offlineClients, "expired")
+}
+
+// removeHookStore calls onRemove before removing the session.
+type removeHookStore struct {
+	persistenceSession.Store
+	onRemove func(clientId string)
+}
+
+func (r *removeHookStore) Remove(ctx context.Context, clientId string) error {
+	r.onRemove(clientId)
+	return r.Store.Remove(ctx, clientId)
+}
+
+func TestServer_removeExpiredSessions_reconnect(t *testing.T) {
+	ctx := context.Background()
+	store, err := sessionMemory.New()(&config.StoreType{})
+	assert.NoError(t, err)
+	reconnected := make(chan struct{})
+	hookStore := &removeHookStore{Store: store}
+	s := &server{
+		config:            &config.DefaultMqtt,
+		clients:           make(map[string]*client),
+		offlineClients:    make(map[string]time.Time),
+		queueStores:       make(map[string]queue.Queue),
+		unackStores:       make(map[string]unack.Store),
+		willMessages:      make(map[string]*willMessage),
+		removingSessions:  make(map[string]chan struct{}),
+		sessionStore:      hookStore,
+		subscriptionStore: memory.New(),
+		log:               xlog.LoggerModule("server"),
+	}
+	// the client reconnects while its expired session is being removed.
+	hookStore.onRemove = func(clientId string) {
+		// s.mu is not held during the removal.
+		s.registerClient(&client{clientId: clientId})
+		go func() {
+			defer close(reconnected)
+			// the session is restored after the removal, see connectAuthentication.
+			s.waitSessionRemoved(clientId)
+			assert.NoError(t, store.Set(ctx, &session.Session{ClientId: clientId, ConnectedAt: time.Now(), ExpiryInterval: 60}))
+			_, err := s.subscriptionStore.Subscribe(ctx, clientId, &sub.Subscription{TopicFilter: "a/b", QoS: packet.QoS1})
+			assert.NoError(t, err)
+		}()
+	}
+	now := time.Now()
+	assert.NoError(t, store.Set(ctx, &session.Session{ClientId: "expired", ConnectedAt: now.Add(-time.Hour), ExpiryInterval: 60}))
+	s.offlineClients["expired"] = now.Add(-time.Second)
+
+	s.removeExpiredSessions(ctx, now)
+	<-reconnected
+
+	sess, err := store.Get(ctx, "expired")
+	assert.NoError(t, err)
+	assert.NotNil(t, sess)
+	assert.Len(t, subscription.GetClientSubscriptions(ctx, s.subscriptionStore, "expired", subscription.TypeAll), 1)
+	assert.Contains(t, s.clients, "expired")
+}
+
+func TestServer_DisconnectClient(t *testing.T) {
+	s := newTestServer(t)
+	assert.False(t, s.DisconnectClient("a", code.AdminAction, nil))
+
+	t.Run("v5", func(t *testing.T) {
+		c, _ := dialTestServer(t, s, newTestConnect(packet.Version5, "v5", true))
+		assert.True(t, s.DisconnectClient("v5", code.AdminAction, &packet.Properties{ReasonString: []byte("kick")}))
+		if disconnect, ok := c.read().(*packet.Disconnect); assert.True(t, ok) {
+			assert.Equal(t, code.AdminAction, disconnect.Code)
+			assert.Equal(t, []byte("kick"), disconnect.Properties.ReasonString)
+		}
+		// [MQTT-3.14.4-1]
+		assert.Nil(t, c.read())
+	})
+
+	t.Run("v3", func(t *testing.T) {
+		c, _ := dialTestServer(t, s, newTestConnect(packet.Version311, "v3", true))
+		assert.True(t, s.DisconnectClient("v3", code.AdminAction, nil))
+		// the DISCONNECT packet is not sent to the v3 client.
+		assert.Nil(t, c.read())
+	})
+}
@@ -0,0 +1,195 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
+	sess "github.com/yunqi/lighthouse/internal/session"
+	"go.uber.org/zap"
+	"time"
+)
+
+// maxSessionExpiry returns the maximum Session Expiry Interval in seconds.
+func (s *server) maxSessionExpiry() uint32 {
+	return uint32(s.config.SessionExpiry / time.Second)
+}
+
+// sessionExpiryCheck removes the expired sessions periodically until the server exits.
+func (s *server) sessionExpiryCheck() {
+	if s.config.SessionExpiryCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.config.SessionExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.exit:
+			return
+		case now := <-ticker.C:
+			s.removeExpiredSessions(context.Background(), now)
+		}
+	}
+}
+
+// removeExpiredSessions removes the sessions of the offline clients which are expired.
+func (s *server) removeExpiredSessions(ctx context.Context, now time.Time) {
+	var expired []*sess.Session
+	// the sessions are removed after iterating, the store may be locked during iterating.
+	err := s.sessionStore.Iterate(ctx, func(session *sess.Session) bool {
+		if s.isSessionExpired(session, now) {
+			expired = append(expired, session)
+		}
+		return true
+	})
+	if err != nil {
+		s.log.Error("iterate sessions", zap.Error(err))
+		return
+	}
+	for _, session := range expired {
+		s.mu.Lock()
+		// the client may have reconnected since iterating.
+		if !s.sessionExpired(session, now) {
+			s.mu.Unlock()
+			continue
+		}
+		s.log.Debug("session expired", zap.String("clientId", session.ClientId))
+		removing := s.detachSessionLocked(session.ClientId)
+		s.mu.Unlock()
+		s.removeSessionState(ctx, removing)
+	}
+}
+
+// isSessionExpired returns whether the session of the offline client is expired.
+func (s *server) isSessionExpired(session *sess.Session, now time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sessionExpired(session, now)
+}
+
+// sessionExpired is like isSessionExpired, the caller must hold s.mu.
+func (s *server) sessionExpired(session *sess.Session, now time.Time) bool {
+	if _, ok := s.clients[session.ClientId]; ok {
+		return false
+	}
+	if _, ok := s.removingSessions[session.ClientId]; ok {
+		return false
+	}
+	if expiredAt, ok := s.offlineClients[session.ClientId]; ok {
+		return !now.Before(expiredAt)
+	}
+	// the client has not connected since the server started.
+	return session.IsExpired(now)
+}
+
+// sessionOffline is called when the network connection of the client is closed.
+// The session ends immediately if the Session Expiry Interval is 0. [MQTT-3.1.2-23]
+func (s *server) sessionOffline(ctx context.Context, clientId string, expiry uint32) {
+	if expiry == 0 {
+		s.removeSession(ctx, clientId)
+		return
+	}
+	s.mu.Lock()
+	s.offlineClients[clientId] = time.Now().Add(time.Duration(expiry) * time.Second)
+	s.mu.Unlock()
+}
+
+// removeSession ends the session of the offline client.
+// It removes the session state and publishes the delayed will message.
+func (s *server) removeSession(ctx context.Context, clientId string) {
+	s.mu.Lock()
+	if _, ok := s.clients[clientId]; ok {
+		// the client has reconnected.
+		s.mu.Unlock()
+		return
+	}
+	removing := s.detachSessionLocked(clientId)
+	s.mu.Unlock()
+	s.removeSessionState(ctx, removing)
+}
+
+// removingSession is the session state which is detached from the server and being removed from the stores.
+type removingSession struct {
+	clientId   string
+	queueStore queue.Queue
+	unackStore unack.Store
+	willMsg    *message.Message
+	// done is closed after the session state has been removed.
+	done chan struct{}
+}
+
+// detachSessionLocked detaches the session state of the offline client from the server
+// and marks the session as being removed. The caller must hold s.mu.
+// The stores are not accessed, so that s.mu is not held during the I/O, see removeSessionState.
+func (s *server) detachSessionLocked(clientId string) *removingSession {
+	removing := &removingSession{
+		clientId:   clientId,
+		queueStore: s.queueStores[clientId],
+		unackStore: s.unackStores[clientId],
+		willMsg:    s.cancelWillMessageLocked(clientId),
+		done:       make(chan struct{}),
+	}
+	delete(s.queueStores, clientId)
+	delete(s.unackStores, clientId)
+	delete(s.offlineClients, clientId)
+	s.removingSessions[clientId] = removing.done
+	return removing
+}
+
+// removeSessionState removes the detached session state from the stores without holding s.mu,
+// then publishes the pending will message.
+// A reconnecting client waits for the removal before restoring its session, see waitSessionRemoved.
+func (s *server) removeSessionState(ctx context.Context, removing *removingSession) {
+	clientId := removing.clientId
+	logger := s.log.WithContext(ctx)
+	if err := s.sessionStore.Remove(ctx, clientId); err != nil {
+		logger.Error("remove session", zap.String("clientId", clientId), zap.Error(err))
+	}
+	if err := s.subscriptionStore.UnsubscribeAll(ctx, clientId); err != nil {
+		logger.Error("unsubscribe all", zap.String("clientId", clientId), zap.Error(err))
+	}
+	if removing.queueStore != nil {
+		if err := removing.queueStore.Clean(ctx); err != nil {
+			logger.Error("clean queue", zap.String("clientId", clientId), zap.Error(err))
+		}
+	}
+	if removing.unackStore != nil {
+		if err := removing.unackStore.Init(ctx, true); err != nil {
+			logger.Error("clean unack store", zap.String("clientId", clientId), zap.Error(err))
+		}
+	}
+	s.mu.Lock()
+	delete(s.removingSessions, clientId)
+	s.mu.Unlock()
+	close(removing.done)
+	// The will message is published when the session ends. [MQTT-3.1.3-9]
+	if removing.willMsg != nil {
+		s.publishWillMessage(ctx, clientId, removing.willMsg)
+	}
+}
+
+// waitSessionRemoved waits until the session of the client has been removed if it is being removed.
+func (s *server) waitSessionRemoved(clientId string) {
+	s.mu.RLock()
+	done, ok := s.removingSessions[clientId]
+	s.mu.RUnlock()
+	if ok {
+		<-done
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// sharedDispatcher chooses the subscriber of a shared subscription group which receives the message.
+	sharedDispatcher struct {
+		strategy string
+		mu       sync.Mutex
+		rand     *rand.Rand
+		// next stores the index of the next subscriber of each group, used by the round-robin strategy.
+		next map[string]int
+	}
+	// sharedMember is a subscriber of a shared subscription group.
+	sharedMember struct {
+		clientId     string
+		subscription *sub.Subscription
+	}
+)
+
+func newSharedDispatcher(strategy string) *sharedDispatcher {
+	return &sharedDispatcher{
+		strategy: strategy,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		next:     make(map[string]int),
+	}
+}
+
+// order returns the members of the group in order of preference.
+// The first member is the one chosen by the strategy, the others are used for retrying.
+func (d *sharedDispatcher) order(group string, members []sharedMember, publisher, topic string, inflight func(clientId string) int) []sharedMember {
+	// sort the members so that the hash strategies always choose the same member.
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].clientId < members[j].clientId
+	})
+	var start int
+	switch d.strategy {
+	case config.SharedSubStrategyRoundRobin:
+		d.mu.Lock()
+		start = d.next[group] % len(members)
+		d.next[group] = start + 1
+		d.mu.Unlock()
+	case config.SharedSubStrategyLeastInflight:
+		counts := make(map[string]int, len(members))
+		for _, member := range members {
+			counts[member.clientId] = inflight(member.clientId)
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return counts[members[i].clientId] < counts[members[j].clientId]
+		})
+		return members
+	case config.SharedSubStrategyClientIdHash:
+		start = int(hashString(publisher) % uint32(len(members)))
+	case config.SharedSubStrategyTopicHash:
+		start = int(hashString(topic) % uint32(len(members)))
+	default:
+		d.mu.Lock()
+		start = d.rand.Intn(len(members))
+		d.mu.Unlock()
+	}
+	return append(members[start:], members[:start]...)
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// deliverSharedMessage delivers the message to one subscriber of each matching shared subscription group.
+// If the chosen subscriber is offline or its queue rejects the message, another subscriber is tried.
+// The offline subscribers are only tried when none of the online subscribers accepts the message.
+func (s *server) deliverSharedMessage(ctx context.Context, publisher string, msg *message.Message, now time.Time) (matched bool) {
+	groups := make(map[string][]sharedMember)
+	clientSubscriptions := subscription.GetTopicMatched(ctx, s.subscriptionStore, msg.Topic, subscription.TypeShared)
+	for clientId, subscriptions := range clientSubscriptions {
+		for _, subscription := range subscriptions {
+			group := subscription.GetFullTopicName()
+			groups[group] = append(groups[group], sharedMember{clientId: clientId, subscription: subscription})
+		}
+	}
+
+	for group, members := range groups {
+		matched = true
+		members = s.sharedDispatcher.order(group, members, publisher, msg.Topic, s.clientInflight)
+		online, offline := s.partitionOnline(members)
+		delivered := false
+		for _, member := range append(online, offline...) {
+			m := subscriptionMessage(msg, member.subscription)
+			if s.addMessageToQueue(ctx, member.clientId, m, member.subscription.QoS, now) {
+				delivered = true
+				break
+			}
+			s.log.WithContext(ctx).Debug("retry shared subscription", zap.String("group", group), zap.String("clientId", member.clientId))
+		}
+		if !delivered {
+			s.log.WithContext(ctx).Warn("no subscriber accepts the shared message", zap.String("group", group), zap.String("topic", msg.Topic))
+		}
+	}
+	return matched
+}
+
+// partitionOnline splits the members into the online members and the offline members, keeping the order.
+func (s *server) partitionOnline(members []sharedMember) (online, offline []sharedMember) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, member := range members {
+		if _, ok := s.clients[member.clientId]; ok {
+			online = append(online, member)
+		} else {
+			offline = append(offline, member)
+		}
+	}
+	return
+}
+
+// clientInflight returns the number of inflight messages of the online client.
+func (s *server) clientInflight(clientId string) int {
+	s.mu.RLock()
+	c, ok := s.clients[clientId]
+	s.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return c.inflightCount()
+}
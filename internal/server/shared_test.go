@@ -0,0 +1,131 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	sessionMemory "github.com/yunqi/lighthouse/internal/persistence/session/memory"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
+	unackMem "github.com/yunqi/lighthouse/internal/persistence/unack/mem"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"testing"
+	"time"
+)
+
+func TestSharedDispatcher_order(t *testing.T) {
+	members := func() []sharedMember {
+		return []sharedMember{{clientId: "c"}, {clientId: "a"}, {clientId: "b"}}
+	}
+	ids := func(members []sharedMember) (rs []string) {
+		for _, member := range members {
+			rs = append(rs, member.clientId)
+		}
+		return
+	}
+	noInflight := func(string) int { return 0 }
+
+	t.Run("round robin", func(t *testing.T) {
+		d := newSharedDispatcher(config.SharedSubStrategyRoundRobin)
+		assert.Equal(t, []string{"a", "b", "c"}, ids(d.order("g", members(), "p", "t", noInflight)))
+		assert.Equal(t, []string{"b", "c", "a"}, ids(d.order("g", members(), "p", "t", noInflight)))
+		assert.Equal(t, []string{"c", "a", "b"}, ids(d.order("g", members(), "p", "t", noInflight)))
+		assert.Equal(t, []string{"a", "b", "c"}, ids(d.order("g", members(), "p", "t", noInflight)))
+	})
+
+	t.Run("least inflight", func(t *testing.T) {
+		d := newSharedDispatcher(config.SharedSubStrategyLeastInflight)
+		inflight := map[string]int{"a": 3, "b": 1, "c": 2}
+		assert.Equal(t, []string{"b", "c", "a"}, ids(d.order("g", members(), "p", "t", func(clientId string) int {
+			return inflight[clientId]
+		})))
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		for _, strategy := range []string{config.SharedSubStrategyClientIdHash, config.SharedSubStrategyTopicHash} {
+			d := newSharedDispatcher(strategy)
+			first := ids(d.order("g", members(), "p", "t", noInflight))
+			assert.Len(t, first, 3)
+			for i := 0; i < 10; i++ {
+				assert.Equal(t, first, ids(d.order("g", members(), "p", "t", noInflight)))
+			}
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		d := newSharedDispatcher(config.SharedSubStrategyRandom)
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, ids(d.order("g", members(), "p", "t", noInflight)))
+	})
+}
+
+func TestServer_clientInflight(t *testing.T) {
+	ctx := context.Background()
+	sessionStore, err := sessionMemory.New()(&config.StoreType{})
+	assert.NoError(t, err)
+	mqtt := config.DefaultMqtt
+	mqtt.SharedSubStrategy = config.SharedSubStrategyLeastInflight
+	s := &server{
+		config:            &mqtt,
+		clients:           make(map[string]*client),
+		offlineClients:    make(map[string]time.Time),
+		queueStores:       make(map[string]queue.Queue),
+		unackStores:       make(map[string]unack.Store),
+		willMessages:      make(map[string]*willMessage),
+		removingSessions:  make(map[string]chan struct{}),
+		sessionStore:      sessionStore,
+		subscriptionStore: memory.New(),
+		newQueueStore:     mem.NewStore(),
+		queueConfig:       &config.StoreType{},
+		newUnackStore:     unackMem.NewStore(),
+		unackConfig:       &config.StoreType{},
+		sharedDispatcher:  newSharedDispatcher(mqtt.SharedSubStrategy),
+		log:               xlog.LoggerModule("server"),
+	}
+	connect := func(clientId string) *client {
+		c := &client{
+			server:            s,
+			out:               make(chan packet.Packet, 8),
+			closed:            make(chan struct{}),
+			connected:         make(chan struct{}),
+			log:               xlog.LoggerModule("client"),
+			subscriptionStore: s.subscriptionStore,
+		}
+		assert.True(t, c.connectAuthentication(ctx, &packet.Connect{
+			Version:      packet.Version5,
+			ClientId:     []byte(clientId),
+			ConnectFlags: packet.ConnectFlags{CleanSession: true},
+		}, nil))
+		assert.IsType(t, &packet.Connack{}, <-c.out)
+		return c
+	}
+	a, b := connect("a"), connect("b")
+	sendInflight(t, a, packet.QoS1)
+	sendInflight(t, a, packet.QoS1)
+	sendInflight(t, b, packet.QoS1)
+
+	assert.Equal(t, 2, s.clientInflight("a"))
+	assert.Equal(t, 1, s.clientInflight("b"))
+	assert.Zero(t, s.clientInflight("offline"))
+
+	members := []sharedMember{{clientId: "a"}, {clientId: "b"}}
+	assert.Equal(t, "b", s.sharedDispatcher.order("g", members, "p", "t", s.clientInflight)[0].clientId)
+}
@@ -0,0 +1,123 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"container/list"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/xerror"
+)
+
+type (
+	// inboundTopicAliases maps the Topic Aliases sent by the client to the topic names.
+	// The mapping is scoped to the network connection. [MQTT-3.3.2-10]
+	inboundTopicAliases struct {
+		max     uint16
+		aliases map[uint16][]byte
+	}
+	// outboundTopicAliases assigns the Topic Aliases of the PUBLISH packets sent to the client.
+	// The least recently used alias is replaced when all aliases have been used.
+	outboundTopicAliases struct {
+		max uint16
+		// topics stores the elements of lru, key by topic name.
+		topics map[string]*list.Element
+		// lru stores the *topicAlias ordered by the last used time, the front is the most recently used.
+		lru *list.List
+	}
+	topicAlias struct {
+		topic string
+		alias uint16
+	}
+)
+
+func newInboundTopicAliases(max uint16) *inboundTopicAliases {
+	return &inboundTopicAliases{max: max, aliases: make(map[uint16][]byte)}
+}
+
+// resolve sets the topic name of the PUBLISH packet by the Topic Alias,
+// or updates the mapping if the PUBLISH packet contains both the Topic Alias and the topic name.
+func (a *inboundTopicAliases) resolve(publish *packet.Publish) *xerror.Error {
+	if publish.Properties == nil || publish.Properties.TopicAlias == nil {
+		return nil
+	}
+	alias := *publish.Properties.TopicAlias
+	// [MQTT-3.3.2-9]
+	if alias > a.max {
+		return xerror.NewError(code.TopicAliasInvalid)
+	}
+	if len(publish.TopicName) != 0 {
+		a.aliases[alias] = publish.TopicName
+		return nil
+	}
+	topic, ok := a.aliases[alias]
+	if !ok {
+		// It is a Protocol Error if the Topic Name is zero length and there is no mapping for the Topic Alias.
+		return xerror.ErrProtocol
+	}
+	publish.TopicName = topic
+	return nil
+}
+
+func newOutboundTopicAliases(max uint16) *outboundTopicAliases {
+	return &outboundTopicAliases{max: max, topics: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the Topic Alias of the topic, exist reports whether the alias has been sent to the client.
+// It returns 0 if the client does not accept Topic Aliases.
+func (a *outboundTopicAliases) get(topic string) (alias uint16, exist bool) {
+	if a.max == 0 {
+		return 0, false
+	}
+	if e, ok := a.topics[topic]; ok {
+		a.lru.MoveToFront(e)
+		return e.Value.(*topicAlias).alias, true
+	}
+	var ta *topicAlias
+	if uint16(a.lru.Len()) < a.max {
+		ta = &topicAlias{topic: topic, alias: uint16(a.lru.Len()) + 1}
+	} else {
+		e := a.lru.Back()
+		a.lru.Remove(e)
+		ta = e.Value.(*topicAlias)
+		delete(a.topics, ta.topic)
+		ta.topic = topic
+	}
+	a.topics[topic] = a.lru.PushFront(ta)
+	return ta.alias, false
+}
+
+// apply replaces the topic name of the PUBLISH packet with the Topic Alias.
+// The packets sent to the client MUST be applied in order.
+func (a *outboundTopicAliases) apply(publish *packet.Publish) {
+	if !packet.IsVersion5(publish.Version) {
+		return
+	}
+	alias, exist := a.get(string(publish.TopicName))
+	if alias == 0 {
+		return
+	}
+	props := packet.Properties{}
+	if publish.Properties != nil {
+		props = *publish.Properties
+	}
+	props.TopicAlias = &alias
+	publish.Properties = &props
+	if exist {
+		publish.TopicName = nil
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func TestInboundTopicAliases_resolve(t *testing.T) {
+	a := assert.New(t)
+	aliases := newInboundTopicAliases(2)
+	publish := func(topic string, alias uint16) *packet.Publish {
+		return &packet.Publish{Version: packet.Version5, TopicName: []byte(topic), Properties: &packet.Properties{TopicAlias: &alias}}
+	}
+
+	p := publish("", 1)
+	a.Equal(xerror.ErrProtocol, aliases.resolve(p))
+
+	a.Nil(aliases.resolve(publish("a/b", 1)))
+	p = publish("", 1)
+	a.Nil(aliases.resolve(p))
+	a.Equal("a/b", string(p.TopicName))
+
+	// the mapping can be changed by the client.
+	a.Nil(aliases.resolve(publish("c", 1)))
+	p = publish("", 1)
+	a.Nil(aliases.resolve(p))
+	a.Equal("c", string(p.TopicName))
+
+	a.Equal(code.TopicAliasInvalid, aliases.resolve(publish("d", 3)).Code)
+	a.Nil(aliases.resolve(&packet.Publish{Version: packet.Version5, TopicName: []byte("e")}))
+}
+
+func TestOutboundTopicAliases_get(t *testing.T) {
+	a := assert.New(t)
+	aliases := newOutboundTopicAliases(2)
+	get := func(topic string) []interface{} {
+		alias, exist := aliases.get(topic)
+		return []interface{}{alias, exist}
+	}
+	a.Equal([]interface{}{uint16(1), false}, get("a"))
+	a.Equal([]interface{}{uint16(2), false}, get("b"))
+	a.Equal([]interface{}{uint16(1), true}, get("a"))
+	// "b" is the least recently used.
+	a.Equal([]interface{}{uint16(2), false}, get("c"))
+	a.Equal([]interface{}{uint16(1), true}, get("a"))
+	a.Equal([]interface{}{uint16(2), false}, get("b"))
+
+	alias, exist := newOutboundTopicAliases(0).get("a")
+	a.Zero(alias)
+	a.False(exist)
+}
+
+func TestOutboundTopicAliases_apply(t *testing.T) {
+	a := assert.New(t)
+	aliases := newOutboundTopicAliases(1)
+	p := &packet.Publish{Version: packet.Version5, TopicName: []byte("a")}
+	aliases.apply(p)
+	a.Equal("a", string(p.TopicName))
+	a.Equal(uint16(1), *p.Properties.TopicAlias)
+
+	p = &packet.Publish{Version: packet.Version5, TopicName: []byte("a")}
+	aliases.apply(p)
+	a.Empty(p.TopicName)
+	a.Equal(uint16(1), *p.Properties.TopicAlias)
+
+	p = &packet.Publish{Version: packet.Version311, TopicName: []byte("a")}
+	aliases.apply(p)
+	a.Equal("a", string(p.TopicName))
+	a.Nil(p.Properties)
+}
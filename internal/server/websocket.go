@@ -0,0 +1,132 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultWebsocketPath is the default path of the websocket endpoint.
+const defaultWebsocketPath = "/mqtt"
+
+// websocketSubprotocols is the supported subprotocols, "mqttv3.1" is used by some MQTT v3.1 clients. [MQTT-6.0.0-3]
+var websocketSubprotocols = []string{"mqtt", "mqttv3.1"}
+
+// errWebsocketTextMessage is returned when the client sends the data in text frame. [MQTT-6.0.0-1]
+var errWebsocketTextMessage = errors.New("websocket: MQTT packets must be sent in binary frames")
+
+// wsConn adapts the websocket connection to net.Conn, so that the client can read and write MQTT packets as TCP.
+// The MQTT packets are not aligned on the websocket frames, a packet may be split into multiple messages. [MQTT-6.0.0-2]
+type wsConn struct {
+	*websocket.Conn
+	// reader is the reader of the current websocket message.
+	reader io.Reader
+}
+
+var _ net.Conn = (*wsConn)(nil)
+
+func (c *wsConn) Read(b []byte) (n int, err error) {
+	for {
+		if c.reader == nil {
+			var messageType int
+			messageType, c.reader, err = c.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				return 0, errWebsocketTextMessage
+			}
+		}
+		n, err = c.reader.Read(b)
+		if err == io.EOF {
+			// read the next message.
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (n int, err error) {
+	if err = c.Conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// supportWebsocketSubprotocol returns whether the client requests the MQTT subprotocol.
+func supportWebsocketSubprotocol(r *http.Request) bool {
+	for _, requested := range websocket.Subprotocols(r) {
+		for _, subprotocol := range websocketSubprotocols {
+			if requested == subprotocol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newWebsocketServer returns the http server which upgrades the requests of the path to MQTT over websocket.
+func (s *server) newWebsocketServer(opts *Options) *http.Server {
+	path := opts.websocketPath
+	if path == "" {
+		path = defaultWebsocketPath
+	}
+	upgrader := &websocket.Upgrader{
+		Subprotocols: websocketSubprotocols,
+		// the origin must be the same as the host if CheckOrigin is nil.
+		CheckOrigin:       opts.websocketCheckOrigin,
+		EnableCompression: opts.websocketCompression,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if !supportWebsocketSubprotocol(r) {
+			http.Error(w, "websocket: the mqtt subprotocol is required", http.StatusBadRequest)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			s.log.Debug("websocket upgrade", zap.String("IP", r.RemoteAddr), zap.Error(err))
+			return
+		}
+		newClient(s, &wsConn{Conn: conn}).listen()
+	})
+	return &http.Server{Handler: mux}
+}
+
+// serveWebsocket serves MQTT over websocket until the server exits.
+func (s *server) serveWebsocket() {
+	if err := s.websocketServer.Serve(s.websocketListener); err != nil && err != http.ErrServerClosed {
+		s.log.Error("serve websocket", zap.Error(err))
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/xtrace"
+	"go.opentelemetry.io/otel"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWsConn(t *testing.T) {
+	upgrader := &websocket.Upgrader{Subprotocols: websocketSubprotocols}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		// a packet split into multiple messages.
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte{0xc0})
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte{0x00, 0xd0})
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte{0x00})
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("a"))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	c := &wsConn{Conn: conn}
+	defer c.Close()
+
+	b := make([]byte, 4)
+	_, err = io.ReadFull(c, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xc0, 0x00, 0xd0, 0x00}, b)
+	_, err = c.Read(b)
+	assert.ErrorIs(t, err, errWebsocketTextMessage)
+
+	n, err := c.Write([]byte{0xe0, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestSupportWebsocketSubprotocol(t *testing.T) {
+	for protocols, want := range map[string]bool{
+		"":             false,
+		"mqtt":         true,
+		"mqttv3.1":     true,
+		"chat, mqtt":   true,
+		"chat, mqttv5": false,
+		"wamp":         false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/mqtt", nil)
+		if protocols != "" {
+			r.Header.Set("Sec-Websocket-Protocol", protocols)
+		}
+		assert.Equal(t, want, supportWebsocketSubprotocol(r), protocols)
+	}
+}
+
+// newWebsocketTestServer returns the test http server which serves MQTT over websocket at "/mqtt".
+func newWebsocketTestServer(t *testing.T, opts ...Option) *httptest.Server {
+	store := config.StoreType{Type: persistence.Memory}
+	options := loadServerOptions(append([]Option{
+		WithTcpListen("127.0.0.1:0"),
+		WithPersistence(&config.Persistence{Session: store, Subscription: store, Queue: store, Retained: store, Unack: store}),
+	}, opts...)...)
+	s := &server{}
+	s.init(options)
+	s.tracer = otel.GetTracerProvider().Tracer(xtrace.Name)
+	// only the websocket server is used.
+	assert.NoError(t, s.tcpListener.Close())
+	server := httptest.NewServer(s.newWebsocketServer(options).Handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestServer_newWebsocketServer(t *testing.T) {
+	t.Run("connect", func(t *testing.T) {
+		server := newWebsocketTestServer(t)
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + defaultWebsocketPath
+		conn, _, err := (&websocket.Dialer{Subprotocols: []string{"mqtt"}}).Dial(url, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "mqtt", conn.Subprotocol())
+		c := &wsConn{Conn: conn}
+		defer c.Close()
+
+		b := &bytes.Buffer{}
+		assert.NoError(t, (&packet.Connect{
+			FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+			Version:       packet.Version5,
+			ProtocolName:  []byte("MQTT"),
+			ProtocolLevel: byte(packet.Version5),
+			ConnectFlags:  packet.ConnectFlags{CleanSession: true},
+			KeepAlive:     60,
+			ClientId:      []byte("ws"),
+		}).Encode(b))
+		_, err = c.Write(b.Bytes())
+		assert.NoError(t, err)
+		assert.NoError(t, c.SetDeadline(time.Now().Add(3*time.Second)))
+		p, err := packet.NewReader(c).Read()
+		if assert.NoError(t, err) && assert.IsType(t, &packet.Connack{}, p) {
+			assert.Equal(t, code.Success, p.(*packet.Connack).Code)
+		}
+	})
+
+	t.Run("subprotocol required", func(t *testing.T) {
+		server := newWebsocketTestServer(t)
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + defaultWebsocketPath
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("check origin", func(t *testing.T) {
+		server := newWebsocketTestServer(t, WithWebsocketCheckOrigin(func(r *http.Request) bool {
+			return false
+		}))
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + defaultWebsocketPath
+		_, resp, err := (&websocket.Dialer{Subprotocols: []string{"mqtt"}}).Dial(url, nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		}
+	})
+}
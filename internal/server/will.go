@@ -0,0 +1,88 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"go.uber.org/zap"
+	"time"
+)
+
+// willMessage is a will message waiting for the Will Delay Interval to elapse.
+type willMessage struct {
+	msg   *message.Message
+	timer *time.Timer
+}
+
+// publishWillMessage publishes the will message through the normal publish path.
+func (s *server) publishWillMessage(ctx context.Context, clientId string, msg *message.Message) {
+	s.log.WithContext(ctx).Debug("publish will message", zap.String("clientId", clientId), zap.String("topic", msg.Topic))
+	if msg.Retained {
+		if !s.config.RetainAvailable {
+			return
+		}
+		s.retainMessage(ctx, msg)
+	}
+	s.deliverMessage(ctx, clientId, msg)
+}
+
+// addWillMessage publishes the will message after the Will Delay Interval.
+// The will message is published immediately if the delay is 0. [MQTT-3.1.3-9]
+func (s *server) addWillMessage(clientId string, msg *message.Message, delay uint32) {
+	if delay == 0 {
+		s.publishWillMessage(context.Background(), clientId, msg)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.willMessages[clientId]; ok {
+		old.timer.Stop()
+	}
+	w := &willMessage{msg: msg}
+	w.timer = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+		s.mu.Lock()
+		if s.willMessages[clientId] != w {
+			s.mu.Unlock()
+			return
+		}
+		delete(s.willMessages, clientId)
+		s.mu.Unlock()
+		s.publishWillMessage(context.Background(), clientId, msg)
+	})
+	s.willMessages[clientId] = w
+}
+
+// cancelWillMessage cancels the delayed will message of the client.
+// It returns the will message if the will message has not been published yet.
+func (s *server) cancelWillMessage(clientId string) *message.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancelWillMessageLocked(clientId)
+}
+
+// cancelWillMessageLocked is like cancelWillMessage, the caller must hold s.mu.
+func (s *server) cancelWillMessageLocked(clientId string) *message.Message {
+	w, ok := s.willMessages[clientId]
+	if !ok {
+		return nil
+	}
+	delete(s.willMessages, clientId)
+	// the timer function checks willMessages before publishing, so it is safe to ignore the result of Stop.
+	w.timer.Stop()
+	return w.msg
+}
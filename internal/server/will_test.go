@@ -0,0 +1,75 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"testing"
+	"time"
+)
+
+// newWillConnect returns the v5 CONNECT packet with the will message whose topic is "will".
+func newWillConnect(clientId string, willDelay uint32) *packet.Connect {
+	sessionExpiry := uint32(60)
+	connect := newTestConnect(packet.Version5, clientId, false)
+	connect.Properties = &packet.Properties{SessionExpiryInterval: &sessionExpiry}
+	connect.WillFlag = true
+	connect.WillTopic = []byte("will")
+	connect.WillMessage = []byte(clientId)
+	connect.WillProperties = &packet.Properties{WillDelayInterval: &willDelay}
+	return connect
+}
+
+func TestServer_willMessage(t *testing.T) {
+	s := newTestServer(t)
+	watcher, _ := dialTestServer(t, s, newTestConnect(packet.Version5, "watcher", true))
+	watcher.subscribe(packet.QoS0, "will")
+
+	t.Run("will delay", func(t *testing.T) {
+		c, _ := dialTestServer(t, s, newWillConnect("delay", 1))
+		closedAt := time.Now()
+		closeTestConn(t, s, c, "delay")
+		watcher.assertNoPacket()
+		// [MQTT-3.1.3-9]
+		watcher.assertPublish("will", "delay")
+		assert.GreaterOrEqual(t, time.Since(closedAt), time.Second)
+	})
+
+	t.Run("reconnect in will delay", func(t *testing.T) {
+		c, _ := dialTestServer(t, s, newWillConnect("reconnect", 1))
+		closeTestConn(t, s, c, "reconnect")
+		// the new connection of the session cancels the will message. [MQTT-3.1.3-9]
+		c, connack := dialTestServer(t, s, newWillConnect("reconnect", 1))
+		assert.True(t, connack.SessionPresent)
+		time.Sleep(1100 * time.Millisecond)
+		watcher.assertNoPacket()
+
+		// the will message is deleted by the normal DISCONNECT. [MQTT-3.1.2-10]
+		c.write(&packet.Disconnect{Version: packet.Version5, Code: code.NormalDisconnection})
+		closeTestConn(t, s, c, "reconnect")
+		time.Sleep(1100 * time.Millisecond)
+		watcher.assertNoPacket()
+	})
+
+	t.Run("disconnect with will message", func(t *testing.T) {
+		c, _ := dialTestServer(t, s, newWillConnect("disconnect", 0))
+		c.write(&packet.Disconnect{Version: packet.Version5, Code: code.DisconnectWithWillMessage})
+		watcher.assertPublish("will", "disconnect")
+	})
+}
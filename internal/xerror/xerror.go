@@ -25,7 +25,9 @@ var (
 	ErrMalformed                     = NewError(code.MalformedPacket)
 	ErrProtocol                      = NewError(code.ProtocolError)
 	ErrV3UnacceptableProtocolVersion = NewError(code.V3UnacceptableProtocolVersion)
+	ErrUnsupportedProtocolVersion    = NewError(code.UnsupportedProtocolVersion)
 	ErrV3IdentifierRejected          = NewError(code.V3IdentifierRejected)
+	ErrPacketTooLarge                = NewError(code.PacketTooLarge)
 )
 
 type (